@@ -0,0 +1,23 @@
+//go:build linux
+
+package main
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// currentCPU returns CPU the calling thread runs on, via getcpu(2) which sched_getcpu wraps
+func currentCPU() int {
+	var cpu uint32
+	_, _, errno := syscall.RawSyscall(sysGetcpu, uintptr(unsafe.Pointer(&cpu)), 0, 0)
+	if errno != 0 {
+		return -1
+	}
+	return int(cpu)
+}
+
+// currentThread returns id of the calling OS thread
+func currentThread() int {
+	return syscall.Gettid()
+}
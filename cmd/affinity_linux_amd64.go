@@ -0,0 +1,6 @@
+//go:build linux && amd64
+
+package main
+
+// sysGetcpu is getcpu(2) number, syscall package doesn't define it for amd64
+const sysGetcpu = 309
@@ -0,0 +1,70 @@
+//go:build linux
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"regexp"
+	"runtime"
+	"strconv"
+	"testing"
+)
+
+// onlineCPUs returns number of CPUs the kernel lists, CPU indices are below it
+func onlineCPUs(t *testing.T) int {
+	cpuinfo, err := os.ReadFile("/proc/cpuinfo")
+	if err != nil {
+		t.Skip(err)
+	}
+	return max(bytes.Count(cpuinfo, []byte("\nprocessor\t")), runtime.NumCPU())
+}
+
+func TestCurrentCPU(t *testing.T) {
+	cpus := onlineCPUs(t)
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	for i := 0; i < 100; i++ {
+		if cpu := currentCPU(); cpu < 0 || cpu >= cpus {
+			t.Fatalf("got cpu %d of %d", cpu, cpus)
+		}
+	}
+	// the thread is locked, so it's the same one every time
+	if tid := currentThread(); tid <= 0 || tid != currentThread() {
+		t.Errorf("got thread %d", tid)
+	}
+}
+
+func TestAffinityReport(t *testing.T) {
+	cpus := onlineCPUs(t)
+	setFlags(t, "-affinity-report", "-chunk-bytes", "1024")
+	out := captureDiag(t)
+	data := genMeasurements(5000, 50)
+	mapScan(data, scan, 3)
+
+	// every worker reports sampled CPUs, a sample per chunk it took (none if others took all of them)
+	line := regexp.MustCompile(`^worker (\d): cpus((?: \d+ x\d+)*), (\d+) threads$`)
+	sample := regexp.MustCompile(` (\d+) x(\d+)`)
+	lines := bytes.Split(bytes.TrimSpace(out.Bytes()), []byte{'\n'})
+	if len(lines) != 3 {
+		t.Fatalf("got report\n%s", out)
+	}
+	samples := 0
+	for _, l := range lines {
+		m := line.FindSubmatch(l)
+		if m == nil {
+			t.Fatalf("unexpected line %q", l)
+		}
+		for _, s := range sample.FindAllSubmatch(m[2], -1) {
+			cpu, _ := strconv.Atoi(string(s[1]))
+			n, _ := strconv.Atoi(string(s[2]))
+			if cpu >= cpus {
+				t.Errorf("got cpu %d of %d", cpu, cpus)
+			}
+			samples += n
+		}
+	}
+	if chunks := len(chunkBoundaries(data, (len(data)+1023)/1024)); samples != chunks {
+		t.Errorf("got %d samples, want one per chunk (%d)", samples, chunks)
+	}
+}
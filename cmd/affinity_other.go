@@ -0,0 +1,13 @@
+//go:build !linux
+
+package main
+
+// currentCPU is unknown outside of linux
+func currentCPU() int {
+	return -1
+}
+
+// currentThread is unknown outside of linux
+func currentThread() int {
+	return -1
+}
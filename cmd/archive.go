@@ -0,0 +1,65 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+func isTarGz(path string) bool {
+	return strings.HasSuffix(path, ".tar.gz") || strings.HasSuffix(path, ".tgz")
+}
+
+// scanTarGz streams through gzipped tar archive and merges results of all its text members.
+// Members are processed by the streaming path, so archive is never unpacked in memory or on disk
+func scanTarGz(r io.Reader, workers int) map[string]Agg {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		panic(err)
+	}
+	defer gz.Close()
+
+	merged := make(map[string]Agg, stationsHint())
+	tr := tar.NewReader(gz)
+	for !stopped() {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return merged
+		}
+		if err != nil {
+			panic(err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		br := bufio.NewReader(tr)
+		if !isText(br) {
+			fmt.Fprintf(os.Stderr, "skipping %s: not a text file\n", hdr.Name)
+			continue
+		}
+		if opts.presence {
+			member := scanStreamInto(make(map[string]Agg, stationsHint()), br, workers)
+			markShard(addShard(hdr.Name), member)
+			merged = reduce(merged, member)
+			continue
+		}
+		merged = scanStreamInto(merged, br, workers)
+	}
+	return merged
+}
+
+// isText sniffs the beginning of r without consuming it: text has no NUL bytes
+// (any encoding of station names is fine, see -input-encoding)
+func isText(br *bufio.Reader) bool {
+	head, err := br.Peek(512)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		panic(err)
+	}
+	return bytes.IndexByte(head, 0) < 0
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"testing"
+)
+
+// tarGz returns gzipped tar archive of members, names ending with / are directories
+func tarGz(t *testing.T, members ...[2]string) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	gz := gzip.NewWriter(&b)
+	tw := tar.NewWriter(gz)
+	for _, m := range members {
+		hdr := &tar.Header{Name: m[0], Mode: 0o644, Size: int64(len(m[1])), Typeflag: tar.TypeReg}
+		if m[0][len(m[0])-1] == '/' {
+			hdr.Typeflag, hdr.Mode = tar.TypeDir, 0o755
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(m[1])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func TestScanTarGz(t *testing.T) {
+	setFlags(t)
+	archive := tarGz(t,
+		[2]string{"data/", ""},
+		[2]string{"data/a.txt", "Paris;1.0\nOslo;-2.0\nParis;2.0\n"},
+		[2]string{"data/image.png", "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"},
+		[2]string{"data/b.txt", "Paris;6.0\nRome;10.0"}, // no newline at the end
+	)
+	for _, workers := range []int{1, 4} {
+		got := formatResults(prepareResults(scanTarGz(bytes.NewReader(archive), workers)), "brc")
+		if want := "{Oslo=-2.0/-2.0/-2.0, Paris=1.0/3.0/6.0, Rome=10.0/10.0/10.0}"; got != want {
+			t.Errorf("%d workers: got %s, want %s", workers, got, want)
+		}
+	}
+}
+
+func TestIsTarGz(t *testing.T) {
+	for path, want := range map[string]bool{
+		"measurements.tar.gz": true,
+		"measurements.tgz":    true,
+		"measurements.gz":     false,
+		"measurements.tar":    false,
+		"measurements.txt":    false,
+	} {
+		if got := isTarGz(path); got != want {
+			t.Errorf("%s: got %v, want %v", path, got, want)
+		}
+	}
+}
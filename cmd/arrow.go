@@ -0,0 +1,76 @@
+//go:build arrow
+
+package main
+
+import (
+	"io"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/ipc"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+)
+
+func init() {
+	formats["arrow"] = printArrow
+	formatExts[".arrow"] = "arrow"
+	fixedFields["arrow"] = []field{
+		baseFields[0],
+		baseFields[1],
+		baseFields[2],
+		baseFields[3],
+		{"count", "int", func(_ string, v Agg) any { return v.count }},
+	}
+}
+
+var arrowSchema = arrow.NewSchema([]arrow.Field{
+	{Name: "station", Type: arrow.BinaryTypes.String},
+	{Name: "min", Type: arrow.PrimitiveTypes.Float64, Nullable: true},
+	{Name: "mean", Type: arrow.PrimitiveTypes.Float64, Nullable: true},
+	{Name: "max", Type: arrow.PrimitiveTypes.Float64, Nullable: true},
+	{Name: "count", Type: arrow.PrimitiveTypes.Int64},
+}, nil)
+
+// printArrow writes results as single record batch of Arrow IPC file,
+// stations absent in data (-keys-file) have null values
+func printArrow(data map[string]Agg, keys []string, w io.Writer) {
+	b := array.NewRecordBuilder(memory.DefaultAllocator, arrowSchema)
+	defer b.Release()
+
+	var (
+		station = b.Field(0).(*array.StringBuilder)
+		minB    = b.Field(1).(*array.Float64Builder)
+		meanB   = b.Field(2).(*array.Float64Builder)
+		maxB    = b.Field(3).(*array.Float64Builder)
+		count   = b.Field(4).(*array.Int64Builder)
+	)
+	for _, key := range keys {
+		station.Append(key)
+		v, ok := data[key]
+		if !ok {
+			minB.AppendNull()
+			meanB.AppendNull()
+			maxB.AppendNull()
+			count.Append(0)
+			continue
+		}
+		minB.Append(round(v.min))
+		meanB.Append(round(v.mean()))
+		maxB.Append(round(v.max))
+		count.Append(int64(v.count))
+	}
+
+	rec := b.NewRecord()
+	defer rec.Release()
+
+	fw, err := ipc.NewFileWriter(w, ipc.WithSchema(arrowSchema))
+	if err != nil {
+		panic(err)
+	}
+	if err := fw.Write(rec); err != nil {
+		panic(err)
+	}
+	if err := fw.Close(); err != nil {
+		panic(err)
+	}
+}
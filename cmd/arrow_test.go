@@ -0,0 +1,50 @@
+//go:build arrow
+
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/ipc"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+)
+
+func TestArrowOutput(t *testing.T) {
+	setFlags(t, "-format", "arrow")
+	var b bytes.Buffer
+	results := aggregate("Oslo;-3.5\nHamburg;12.0\nHamburg;-1.0\n", 1)
+	printArrow(results, sortedKeys(results), &b)
+
+	r, err := ipc.NewFileReader(bytes.NewReader(b.Bytes()), ipc.WithAllocator(memory.DefaultAllocator))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if r.NumRecords() != 1 {
+		t.Fatalf("got %d record batches, want 1", r.NumRecords())
+	}
+	rec, err := r.Record(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rec.Schema().Equal(arrowSchema) {
+		t.Errorf("got schema %s", rec.Schema())
+	}
+	if rec.NumRows() != 2 {
+		t.Fatalf("got %d rows, want 2", rec.NumRows())
+	}
+
+	// rows are sorted by station, Hamburg is the first one
+	var (
+		station = rec.Column(0).(*array.String).Value(0)
+		minV    = rec.Column(1).(*array.Float64).Value(0)
+		mean    = rec.Column(2).(*array.Float64).Value(0)
+		maxV    = rec.Column(3).(*array.Float64).Value(0)
+		count   = rec.Column(4).(*array.Int64).Value(0)
+	)
+	if station != "Hamburg" || minV != -1 || mean != 5.5 || maxV != 12 || count != 2 {
+		t.Errorf("got %s %v/%v/%v count %d, want Hamburg -1/5.5/12 count 2", station, minV, mean, maxV, count)
+	}
+}
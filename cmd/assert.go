@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+)
+
+// assertContext is how many bytes around the first difference are shown
+const assertContext = 40
+
+// assertOutput formats results in opts.format and compares them byte by byte with expected file.
+// On mismatch it writes position of the first difference with context to w and returns false
+func assertOutput(results map[string]Agg, expectedPath string, w io.Writer) bool {
+	expected, err := os.ReadFile(expectedPath)
+	if err != nil {
+		panic(err)
+	}
+
+	var actual bytes.Buffer
+	formats[opts.format](results, sortedKeys(results), &actual)
+
+	if bytes.Equal(actual.Bytes(), expected) {
+		fmt.Fprintf(w, "output matches %s\n", expectedPath)
+		return true
+	}
+	writeDiff(w, expectedPath, expected, actual.Bytes())
+	return false
+}
+
+// writeDiff writes position of the first difference of expected and actual (brc output is one long line,
+// so line based diff doesn't help) and a piece of both around it
+func writeDiff(w io.Writer, expectedPath string, expected, actual []byte) {
+	pos := 0
+	for pos < len(expected) && pos < len(actual) && expected[pos] == actual[pos] {
+		pos++
+	}
+	line := bytes.Count(expected[:pos], []byte{'\n'}) + 1
+
+	fmt.Fprintf(w, "output differs from %s at byte %d (line %d)\n", expectedPath, pos, line)
+	fmt.Fprintf(w, "expected: %q\n", around(expected, pos))
+	fmt.Fprintf(w, "  actual: %q\n", around(actual, pos))
+	if len(expected) != len(actual) {
+		fmt.Fprintf(w, "expected %d bytes, got %d bytes\n", len(expected), len(actual))
+	}
+}
+
+func around(b []byte, pos int) []byte {
+	from := max(pos-assertContext, 0)
+	to := min(pos+assertContext, len(b))
+	if from > to {
+		return nil
+	}
+	return b[from:to]
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAssertOutput(t *testing.T) {
+	setFlags(t)
+	results := aggregate("A;1.0\nB;2.0\nA;3.0\n", 1)
+	for _, tt := range []struct {
+		name     string
+		expected string
+		ok       bool
+		report   []string
+	}{
+		{
+			name:     "match",
+			expected: "{A=1.0/2.0/3.0, B=2.0/2.0/2.0}",
+			ok:       true,
+			report:   []string{"output matches"},
+		},
+		{
+			name:     "mismatch",
+			expected: "{A=1.0/2.0/3.0, B=2.0/2.5/3.0}",
+			report: []string{
+				"at byte 24 (line 1)",
+				`expected: "{A=1.0/2.0/3.0, B=2.0/2.5/3.0}"`,
+				`  actual: "{A=1.0/2.0/3.0, B=2.0/2.0/2.0}"`,
+			},
+		},
+		{
+			name:     "trailing newline",
+			expected: "{A=1.0/2.0/3.0, B=2.0/2.0/2.0}\n",
+			report:   []string{"at byte 30 (line 1)", "expected 31 bytes, got 30 bytes"},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var w bytes.Buffer
+			if ok := assertOutput(results, writeFile(t, "expected.txt", tt.expected), &w); ok != tt.ok {
+				t.Errorf("got %v, want %v", ok, tt.ok)
+			}
+			for _, s := range tt.report {
+				if !strings.Contains(w.String(), s) {
+					t.Errorf("report has no %q:\n%s", s, w.String())
+				}
+			}
+		})
+	}
+}
+
+func TestAssertOutputExitCode(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "in.txt"), []byte("A;1.0\nA;3.0\n"), 0o644)
+	os.WriteFile(filepath.Join(dir, "good.txt"), []byte("{A=1.0/2.0/3.0}"), 0o644)
+	os.WriteFile(filepath.Join(dir, "bad.txt"), []byte("{A=1.0/2.0/4.0}"), 0o644)
+
+	if out, code := runMain(t, dir, "-input", "in.txt", "-assert-output", "good.txt"); code != 0 {
+		t.Errorf("matching file: exit %d: %s", code, out)
+	}
+	if out, code := runMain(t, dir, "-input", "in.txt", "-assert-output", "bad.txt"); code != 1 {
+		t.Errorf("mismatching file: exit %d: %s", code, out)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"sync"
+)
+
+// balanceRegion is size of regions newlines are counted in by the first pass of -balance rows
+const balanceRegion = 1 << 20
+
+// rowBoundaries splits data into at most n record aligned [from, to) ranges
+// with roughly equal number of records, unlike chunkBoundaries which makes them of equal size.
+// First pass counts newlines per region in parallel, then boundaries are looked up
+// only inside regions they fall in
+func rowBoundaries(data []byte, n int, workers int) [][2]int {
+	counts := countNewlines(data, workers)
+	total := 0
+	for _, c := range counts {
+		total += c
+	}
+
+	out := make([][2]int, 0, n)
+	var (
+		from   int
+		region int
+		before int // newlines in regions before region
+	)
+	for k := 1; k <= n && from < len(data); k++ {
+		to := len(data)
+		if k < n {
+			// record starts after target-th newline
+			target := total * k / n
+			for region < len(counts) && before+counts[region] < target {
+				before += counts[region]
+				region++
+			}
+			if region < len(counts) {
+				to = max(nthNewline(data[region*balanceRegion:], target-before)+region*balanceRegion, from)
+			}
+		}
+		if to > from {
+			out = append(out, [2]int{from, to})
+			from = to
+		}
+	}
+	return out
+}
+
+// countNewlines returns number of newlines in every balanceRegion of data
+func countNewlines(data []byte, workers int) []int {
+	counts := make([]int, (len(data)+balanceRegion-1)/balanceRegion)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		w := w
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for r := w; r < len(counts); r += workers {
+				region := data[r*balanceRegion : min((r+1)*balanceRegion, len(data))]
+				counts[r] = bytes.Count(region, []byte{'\n'})
+			}
+		}()
+	}
+	wg.Wait()
+	return counts
+}
+
+// nthNewline returns position right after nth newline of data, 0 for n == 0
+func nthNewline(data []byte, n int) int {
+	pos := 0
+	for ; n > 0; n-- {
+		pos += bytes.IndexByte(data[pos:], '\n') + 1
+	}
+	return pos
+}
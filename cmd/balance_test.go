@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestRowBoundaries(t *testing.T) {
+	// skewed: long records first, then short ones, spanning several balanceRegions
+	var skewed bytes.Buffer
+	for i := 0; i < 20000; i++ {
+		skewed.WriteString(strings.Repeat("x", 100) + ";1.0\n")
+	}
+	for i := 0; i < 300000; i++ {
+		skewed.WriteString("y;2.0\n")
+	}
+
+	for _, tt := range []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"one record", []byte("A;1.0\n")},
+		{"few records", []byte("A;1.0\nB;2.0\nC;3.0\n")},
+		{"no trailing newline", []byte("A;1.0\nB;2.0\nC;3.0")},
+		{"generated", genMeasurements(50000, 100)},
+		{"skewed", skewed.Bytes()},
+	} {
+		for _, n := range []int{1, 2, 3, 4, 7} {
+			parts := rowBoundaries(tt.data, n, 3)
+			if len(parts) > n {
+				t.Fatalf("%s, n=%d: %d parts", tt.name, n, len(parts))
+			}
+			total := bytes.Count(tt.data, []byte{'\n'})
+			from := 0
+			for k, p := range parts {
+				if p[0] != from || p[1] <= p[0] {
+					t.Fatalf("%s, n=%d: parts %v are not contiguous", tt.name, n, parts)
+				}
+				if p[1] < len(tt.data) && tt.data[p[1]-1] != '\n' {
+					t.Fatalf("%s, n=%d: part %v ends inside record", tt.name, n, p)
+				}
+				// parts end at total*k/n newlines, so every part but the last (which gets record
+				// without newline) has floor or ceil of total/n records, empty ones are dropped
+				if rows := bytes.Count(tt.data[p[0]:p[1]], []byte{'\n'}); k < len(parts)-1 &&
+					(rows < total/n || rows > (total+n-1)/n) {
+					t.Errorf("%s, n=%d: part %d has %d of %d rows", tt.name, n, k, rows, total)
+				}
+				from = p[1]
+			}
+			if from != len(tt.data) {
+				t.Errorf("%s, n=%d: parts %v end at %d of %d", tt.name, n, parts, from, len(tt.data))
+			}
+		}
+	}
+}
+
+func TestCountNewlines(t *testing.T) {
+	data := bytes.Repeat([]byte("A;1.0\n"), balanceRegion/2) // 3 regions, the last one is not full
+	counts := countNewlines(data, 2)
+	sum := 0
+	for _, c := range counts {
+		sum += c
+	}
+	if len(counts) != 3 || sum != balanceRegion/2 {
+		t.Errorf("got %v", counts)
+	}
+}
@@ -0,0 +1,426 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"runtime"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// benchRows is number of records of benchSample, ~16MB
+const benchRows = 1 << 20
+
+var benchData struct {
+	once sync.Once
+	data []byte
+}
+
+// benchSample returns generated input shared by benchmarks: benchRows records of 400 stations
+func benchSample(b *testing.B) []byte {
+	benchData.once.Do(func() {
+		benchData.data = genMeasurements(benchRows, 400)
+	})
+	b.SetBytes(int64(len(benchData.data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	return benchData.data
+}
+
+func TestMapKeysScans(t *testing.T) {
+	setFlags(t)
+	data := genMeasurements(10000, 100)
+	want := formatResults(scan(data, 0, len(data)), "brc")
+	for name, scan := range map[string]func(data []byte, i int, end int) map[string]Agg{
+		"array-value":    scanArrayKeys,
+		"array-pointer":  scanArrayKeysPtr,
+		"string-value":   scanStringKeysValue,
+		"string-pointer": scanStringKeysPtr,
+	} {
+		if got := formatResults(scan(data, 0, len(data)), "brc"); got != want {
+			t.Errorf("%s differs from scan", name)
+		}
+	}
+}
+
+// keySize is the longest station name of array keyed scans
+const keySize = 50
+
+// scanArrayKeys is scan as it was before string keys: aggregates are kept by value in array keyed map
+// and converted by fixMap at the end
+func scanArrayKeys(data []byte, i int, end int) map[string]Agg {
+	m := make(map[[keySize]byte]Agg)
+	var key [keySize]byte
+	for i < end {
+		key = [keySize]byte{}
+		keyStart := i
+		for data[i] != ';' {
+			key[i-keyStart] = data[i]
+			i++
+		}
+		i++
+		valueStart := i
+		for data[i] != '\n' {
+			i++
+		}
+		value := fastFloat(data[valueStart:i])
+		i++
+
+		agg, ok := m[key]
+		if ok {
+			agg.Add(value)
+		} else {
+			agg = newAgg(value)
+		}
+		m[key] = agg
+	}
+	return fixMap(m)
+}
+
+// scanArrayKeysPtr is scanArrayKeys with pointer values updated in place
+func scanArrayKeysPtr(data []byte, i int, end int) map[string]Agg {
+	m := make(map[[keySize]byte]*Agg)
+	var key [keySize]byte
+	for i < end {
+		key = [keySize]byte{}
+		keyStart := i
+		for data[i] != ';' {
+			key[i-keyStart] = data[i]
+			i++
+		}
+		i++
+		valueStart := i
+		for data[i] != '\n' {
+			i++
+		}
+		value := fastFloat(data[valueStart:i])
+		i++
+
+		if agg := m[key]; agg != nil {
+			agg.Add(value)
+		} else {
+			newValue := newAgg(value)
+			m[key] = &newValue
+		}
+	}
+	out := make(map[[keySize]byte]Agg, len(m))
+	for key, agg := range m {
+		out[key] = *agg
+	}
+	return fixMap(out)
+}
+
+// scanStringKeysValue is scan with aggregates kept by value, so every update is a map assignment
+func scanStringKeysValue(data []byte, i int, end int) map[string]Agg {
+	m := make(map[string]Agg)
+	for i < end {
+		keyStart := i
+		for data[i] != ';' {
+			i++
+		}
+		key := data[keyStart:i]
+		i++
+		valueStart := i
+		for data[i] != '\n' {
+			i++
+		}
+		value := fastFloat(data[valueStart:i])
+		i++
+
+		agg, ok := m[string(key)]
+		if ok {
+			agg.Add(value)
+		} else {
+			agg = newAgg(value)
+		}
+		m[string(key)] = agg
+	}
+	return m
+}
+
+// scanStringKeysPtr is scan without options: string keyed map with pointer values updated in place
+func scanStringKeysPtr(data []byte, i int, end int) map[string]Agg {
+	m := make(map[string]*Agg)
+	for i < end {
+		keyStart := i
+		for data[i] != ';' {
+			i++
+		}
+		key := data[keyStart:i]
+		i++
+		valueStart := i
+		for data[i] != '\n' {
+			i++
+		}
+		value := fastFloat(data[valueStart:i])
+		i++
+
+		if agg := m[string(key)]; agg != nil {
+			agg.Add(value)
+		} else {
+			newValue := newAgg(value)
+			m[string(key)] = &newValue
+		}
+	}
+	return derefMap(m)
+}
+
+// fixMap converts map from [keySize]byte keyed into string keyed, names end at the first zero byte
+func fixMap(m1 map[[keySize]byte]Agg) map[string]Agg {
+	out := make(map[string]Agg, len(m1))
+	for key, agg := range m1 {
+		n := 0
+		for n < keySize && key[n] != 0 {
+			n++
+		}
+		out[string(key[:n])] = agg
+	}
+	return out
+}
+
+// BenchmarkMapKeys compares map key and value types of single goroutine scan loop,
+// scan is the same loop as string-pointer plus checks of options per record
+func BenchmarkMapKeys(b *testing.B) {
+	setFlags(b)
+	for _, bb := range []struct {
+		name string
+		scan func(data []byte, i int, end int) map[string]Agg
+	}{
+		{"array-value", scanArrayKeys},
+		{"string-value", scanStringKeysValue},
+		{"array-pointer", scanArrayKeysPtr},
+		{"string-pointer", scanStringKeysPtr},
+		{"scan", scan},
+	} {
+		b.Run(bb.name, func(b *testing.B) {
+			data := benchSample(b)
+			for i := 0; i < b.N; i++ {
+				bb.scan(data, 0, len(data))
+			}
+		})
+	}
+}
+
+// BenchmarkChunkBytes compares chunk queue of -chunk-bytes with one chunk per worker (0)
+func BenchmarkChunkBytes(b *testing.B) {
+	for _, chunkBytes := range []string{"0", "262144", "1048576", "4194304"} {
+		for _, workers := range []int{1, 4} {
+			b.Run(fmt.Sprintf("chunk=%s/workers=%d", chunkBytes, workers), func(b *testing.B) {
+				setFlags(b, "-chunk-bytes", chunkBytes)
+				data := benchSample(b)
+				for i := 0; i < b.N; i++ {
+					reduce(mapScan(data, scan, workers)...)
+				}
+			})
+		}
+	}
+}
+
+// BenchmarkRowsHint shows allocations of single goroutine scan with maps presized by -rows-hint,
+// benchSample has 400 stations
+func BenchmarkRowsHint(b *testing.B) {
+	for _, hint := range []string{"0", "400", "1000000000"} {
+		b.Run("hint="+hint, func(b *testing.B) {
+			setFlags(b, "-rows-hint", hint)
+			data := benchSample(b)
+			for i := 0; i < b.N; i++ {
+				scan(data, 0, len(data))
+			}
+		})
+	}
+}
+
+// BenchmarkSharedTable compares per-worker maps merged by reduce with -shared-table,
+// one worker per GOMAXPROCS (go test -cpu 1,4,16) over 1MB chunks
+func BenchmarkSharedTable(b *testing.B) {
+	workers := runtime.GOMAXPROCS(0)
+	b.Run("reduce", func(b *testing.B) {
+		setFlags(b, "-chunk-bytes", "1048576")
+		data := benchSample(b)
+		for i := 0; i < b.N; i++ {
+			reduce(mapScan(data, scan, workers)...)
+		}
+	})
+	b.Run("shared", func(b *testing.B) {
+		setFlags(b, "-chunk-bytes", "1048576", "-shared-table")
+		data := benchSample(b)
+		for i := 0; i < b.N; i++ {
+			shared = newSharedTable()
+			mapScan(data, scan, workers)
+			shared.results()
+		}
+		shared = nil
+	})
+}
+
+// slowReader delays every read as if input came from a slow pipe or network
+type slowReader struct {
+	r     io.Reader
+	delay time.Duration
+}
+
+func (s slowReader) Read(p []byte) (int, error) {
+	time.Sleep(s.delay)
+	return s.r.Read(p[:min(len(p), 256<<10)])
+}
+
+// BenchmarkPrefetch compares streaming with -prefetch-buffers, 1 is reading and processing in turn.
+// Memory input is read instantly, slow input takes 1ms per 256KB (~250MB/s), overlap shows only there
+func BenchmarkPrefetch(b *testing.B) {
+	for _, input := range []string{"memory", "slow"} {
+		for _, buffers := range []string{"1", "2", "3"} {
+			b.Run(fmt.Sprintf("%s/buffers=%s", input, buffers), func(b *testing.B) {
+				setFlags(b, "-stream", "-stream-buffer", "1048576", "-prefetch-buffers", buffers)
+				data := benchSample(b)
+				for i := 0; i < b.N; i++ {
+					var r io.Reader = bytes.NewReader(data)
+					if input == "slow" {
+						r = slowReader{r, time.Millisecond}
+					}
+					scanStream(r, runtime.GOMAXPROCS(0))
+				}
+			})
+		}
+	}
+}
+
+// BenchmarkPipeline compares mapScan with parsers and aggregators of -parse-workers/-aggregate-workers
+// over 1MB chunks, go test -cpu sets GOMAXPROCS
+func BenchmarkPipeline(b *testing.B) {
+	b.Run("mapScan", func(b *testing.B) {
+		setFlags(b, "-chunk-bytes", "1048576")
+		data := benchSample(b)
+		for i := 0; i < b.N; i++ {
+			reduce(mapScan(data, scan, runtime.GOMAXPROCS(0))...)
+		}
+	})
+	for _, ratio := range [][2]int{{1, 1}, {2, 1}, {1, 2}, {4, 4}} {
+		b.Run(fmt.Sprintf("P=%d/A=%d", ratio[0], ratio[1]), func(b *testing.B) {
+			setFlags(b, "-chunk-bytes", "1048576",
+				"-parse-workers", fmt.Sprint(ratio[0]), "-aggregate-workers", fmt.Sprint(ratio[1]))
+			data := benchSample(b)
+			for i := 0; i < b.N; i++ {
+				reduce(mapScan(data, scan, ratio[0])...)
+			}
+		})
+	}
+}
+
+// scanScanner is scan written with bufio.Scanner splitting lines and bytes.Cut splitting fields,
+// parse converts value
+func scanScanner(data []byte, parse func(b []byte) float64) map[string]Agg {
+	m := make(map[string]*Agg)
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		key, valueBytes, _ := bytes.Cut(sc.Bytes(), []byte{';'})
+		value := parse(valueBytes)
+		if agg := m[string(key)]; agg != nil {
+			agg.Add(value)
+		} else {
+			newValue := newAgg(value)
+			m[string(key)] = &newValue
+		}
+	}
+	if err := sc.Err(); err != nil {
+		panic(err)
+	}
+	return derefMap(m)
+}
+
+// parseFloat is strconv.ParseFloat of value bytes, input of benchmarks is valid
+func parseFloat(b []byte) float64 {
+	v, err := strconv.ParseFloat(string(b), 64)
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
+
+func TestParserScans(t *testing.T) {
+	setFlags(t)
+	data := genMeasurements(10000, 100)
+	want := formatResults(scan(data, 0, len(data)), "brc")
+	for name, parse := range map[string]func([]byte) float64{"strconv": parseFloat, "fastFloat": fastFloat} {
+		if got := formatResults(scanScanner(data, parse), "brc"); got != want {
+			t.Errorf("scanner with %s differs from scan", name)
+		}
+	}
+}
+
+// BenchmarkParser compares byte loop of scan with bufio.Scanner + bytes.Cut, single goroutine
+func BenchmarkParser(b *testing.B) {
+	setFlags(b)
+	for _, bb := range []struct {
+		name string
+		scan func(data []byte) map[string]Agg
+	}{
+		{"scan", func(data []byte) map[string]Agg { return scan(data, 0, len(data)) }},
+		{"scanner-strconv", func(data []byte) map[string]Agg { return scanScanner(data, parseFloat) }},
+		{"scanner-fastFloat", func(data []byte) map[string]Agg { return scanScanner(data, fastFloat) }},
+	} {
+		b.Run(bb.name, func(b *testing.B) {
+			data := benchSample(b)
+			for i := 0; i < b.N; i++ {
+				bb.scan(data)
+			}
+		})
+	}
+}
+
+var denseData struct {
+	once sync.Once
+	data []byte
+}
+
+// BenchmarkDenseKeys compares map with -dense-keys slice over benchRows records of integer station ids
+// in [0, 10000), single goroutine scan
+func BenchmarkDenseKeys(b *testing.B) {
+	denseData.once.Do(func() {
+		denseData.data = bytes.ReplaceAll(genMeasurements(benchRows, 10000), []byte("Station"), nil)
+	})
+	for _, bb := range []struct {
+		name  string
+		flags []string
+	}{
+		{"map", nil},
+		{"map-rows-hint", []string{"-rows-hint", "10000"}},
+		{"dense", []string{"-dense-keys", "10000"}},
+	} {
+		b.Run(bb.name, func(b *testing.B) {
+			setFlags(b, bb.flags...)
+			data := denseData.data
+			b.SetBytes(int64(len(data)))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				scan(data, 0, len(data))
+			}
+		})
+	}
+}
+
+// BenchmarkOutput compares fmt.Sprintf per station of printResults with -fast-output, brc format of 10000 stations
+func BenchmarkOutput(b *testing.B) {
+	setFlags(b)
+	data := genMeasurements(200000, 10000)
+	results := scan(data, 0, len(data))
+	for _, bb := range []struct {
+		name  string
+		flags []string
+	}{
+		{"sprintf", nil},
+		{"fast-output", []string{"-fast-output"}},
+	} {
+		b.Run(bb.name, func(b *testing.B) {
+			setFlags(b, bb.flags...)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				printResults(results, sortedKeys(results), io.Discard)
+			}
+		})
+	}
+}
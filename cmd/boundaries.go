@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// checkBoundaries computes chunks of data the way mapScan does and verifies them without relying
+// on chunkBoundaries logic: chunks have to follow each other without gaps or overlaps from the start
+// to the end of data, and every chunk has to start at the start of record and end with newline,
+// so no record is split or processed twice. Chunks and violations are written to w,
+// returns false if there are violations
+func checkBoundaries(data []byte, workers int, w io.Writer) bool {
+	return verifyChunks(data, mapBoundaries(data, workers), w)
+}
+
+// verifyChunks checks [from, to) chunks of data for checkBoundaries
+func verifyChunks(data []byte, boundaries [][2]int, w io.Writer) bool {
+	violations := 0
+	violation := func(format string, args ...any) {
+		violations++
+		fmt.Fprintf(w, "violation: "+format+"\n", args...)
+	}
+
+	records, pos := 0, 0
+	for i, c := range boundaries {
+		from, to := c[0], c[1]
+		fmt.Fprintf(w, "chunk %d: [%d, %d) %d bytes\n", i, from, to, to-from)
+		switch {
+		case from > pos:
+			violation("gap of %d bytes [%d, %d) before chunk %d", from-pos, pos, from, i)
+		case from < pos:
+			violation("chunk %d overlaps previous one by %d bytes [%d, %d)", i, pos-from, from, pos)
+		}
+		if from >= to || to > len(data) {
+			violation("chunk %d [%d, %d) is empty or out of input of %d bytes", i, from, to, len(data))
+			pos = max(pos, to)
+			continue
+		}
+		if from > 0 && data[from-1] != '\n' {
+			violation("chunk %d starts in the middle of record %q", i, recordAt(data, from))
+		}
+		if data[to-1] != '\n' {
+			violation("chunk %d ends in the middle of record %q", i, recordAt(data, to-1))
+		}
+		records += bytes.Count(data[from:to], []byte{'\n'})
+		pos = to
+	}
+	if pos != len(data) {
+		violation("chunks end at %d, input is %d bytes", pos, len(data))
+	}
+	if total := bytes.Count(data, []byte{'\n'}); records != total {
+		violation("chunks have %d records, input has %d", records, total)
+	}
+
+	fmt.Fprintf(w, "%d chunks, %d records, %d violations\n", len(boundaries), records, violations)
+	return violations == 0
+}
+
+// recordAt returns line of data which contains byte at i, without newline
+func recordAt(data []byte, i int) []byte {
+	from := bytes.LastIndexByte(data[:i], '\n') + 1
+	to := len(data)
+	if nl := bytes.IndexByte(data[i:], '\n'); nl >= 0 {
+		to = i + nl
+	}
+	return data[from:to]
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestCheckBoundaries(t *testing.T) {
+	// records ending right before, at and right after multiples of chunk size
+	inputs := map[string]string{
+		"chunk sized":     strings.Repeat("A;1.0\n", 50),
+		"one byte longer": strings.Repeat("AB;1.0\n", 50),
+		"one byte short":  strings.Repeat("A;1.\n", 50),
+		"mixed":           strings.Repeat("A;1.0\nBB;-2.0\nC;3.5\n", 20),
+		"no last newline": strings.Repeat("A;1.0\n", 49) + "A;1.0",
+	}
+	for name, input := range inputs {
+		data := terminateLastLine([]byte(input))
+		for _, args := range [][]string{
+			{"-chunk-bytes", "6"},
+			{"-chunk-bytes", "7"},
+			{"-chunk-bytes", "0"},
+			{"-chunk-bytes", "0", "-balance", "rows"},
+			{"-chunk-bytes", "1"},
+		} {
+			setFlags(t, args...)
+			var w bytes.Buffer
+			if !checkBoundaries(data, 4, &w) {
+				t.Errorf("%s, %v:\n%s", name, args, w.String())
+			}
+			if want := "0 violations\n"; !strings.HasSuffix(w.String(), want) {
+				t.Errorf("%s, %v: report ends with %q", name, args, w.String()[max(w.Len()-40, 0):])
+			}
+		}
+	}
+}
+
+func TestVerifyChunks(t *testing.T) {
+	data := []byte("A;1.0\nBB;2.0\nC;3.0\n") // records at 0, 6, 13, input is 19 bytes
+	for _, tt := range []struct {
+		name   string
+		chunks [][2]int
+		want   []string
+	}{
+		{"valid", [][2]int{{0, 6}, {6, 19}}, nil},
+		{"gap", [][2]int{{0, 6}, {13, 19}}, []string{
+			"gap of 7 bytes [6, 13) before chunk 1",
+			"chunks have 2 records, input has 3",
+		}},
+		{"overlap", [][2]int{{0, 13}, {6, 19}}, []string{
+			"chunk 1 overlaps previous one by 7 bytes [6, 13)",
+			"chunks have 4 records, input has 3",
+		}},
+		{"split record", [][2]int{{0, 9}, {9, 19}}, []string{
+			`chunk 0 ends in the middle of record "BB;2.0"`,
+			`chunk 1 starts in the middle of record "BB;2.0"`,
+		}},
+		{"short", [][2]int{{0, 13}}, []string{"chunks end at 13, input is 19 bytes"}},
+		{"empty", [][2]int{{0, 6}, {6, 6}, {6, 19}}, []string{"chunk 1 [6, 6) is empty"}},
+	} {
+		var w bytes.Buffer
+		ok := verifyChunks(data, tt.chunks, &w)
+		if ok != (tt.want == nil) {
+			t.Errorf("%s: got %v:\n%s", tt.name, ok, w.String())
+		}
+		for _, v := range tt.want {
+			if !strings.Contains(w.String(), "violation: "+v) {
+				t.Errorf("%s: no %q in\n%s", tt.name, v, w.String())
+			}
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+)
+
+// canonicalize reads brc result files (possibly concatenated with duplicate stations)
+// and merges them into one entry per station.
+// min and max are merged exactly, but mean can't be recombined without counts,
+// so average of means is taken and stations where it happened are reported to w.
+// With -merge-tolerance duplicates are overlapping shards instead: they have to agree within tolerance
+// (rounding of the same data may differ between machines), and the first entry is kept.
+// Files have values rounded to one decimal only, so tolerance applies to rounded values, not to unrounded means
+func canonicalize(paths []string, w io.Writer) map[string]Agg {
+	out := make(map[string]Agg)
+	duplicates := make(map[string]bool)
+	for _, path := range paths {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			panic(err)
+		}
+		entries, err := parseBrc(content)
+		if err != nil {
+			panic(fmt.Errorf("%s: %w", path, err))
+		}
+		for _, e := range entries {
+			agg, ok := out[e.key]
+			if ok && opts.crossCheck {
+				// values have one decimal, so their difference is only approximately e.g. 0.1
+				if diff := brcDiff(agg, e.agg); diff > opts.mergeTolerance+1e-9 {
+					panic(fmt.Errorf("%s: %s=%s differs from earlier entry %s by %.1f, more than -merge-tolerance",
+						path, e.key, formatBrcValue(e.agg), formatBrcValue(agg), diff))
+				}
+				continue
+			}
+			if ok {
+				agg.Merge(e.agg)
+				duplicates[e.key] = true
+			} else {
+				agg = e.agg
+			}
+			out[e.key] = agg
+		}
+	}
+
+	if len(duplicates) > 0 {
+		keys := make([]string, 0, len(duplicates))
+		for key := range duplicates {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		fmt.Fprintf(w, "warning: mean of duplicated stations is an average of their means, "+
+			"real mean can't be recombined without counts: %v\n", keys)
+	}
+	return out
+}
+
+// brcDiff returns the largest difference between min, mean and max of two parsed entries
+func brcDiff(a, b Agg) float64 {
+	return max(math.Abs(a.min-b.min), math.Abs(a.mean()-b.mean()), math.Abs(a.max-b.max))
+}
+
+// formatBrcValue formats parsed entry back as min/mean/max
+func formatBrcValue(a Agg) string {
+	return fmt.Sprintf("%.1f/%.1f/%.1f", a.min, a.mean(), a.max)
+}
+
+type brcEntry struct {
+	key string
+	agg Agg
+}
+
+// parseBrc parses `{name=min/mean/max, ...}` blocks, any number of them.
+// Mean is kept as sum of single count, so merging entries averages their means.
+// Names may contain ", " (e.g. "Washington, D.C."): entry ends only where min/mean/max follows the last '=',
+// so pieces without '=' are taken as the beginning of the next name
+func parseBrc(content []byte) ([]brcEntry, error) {
+	var entries []brcEntry
+	for {
+		start := bytes.IndexByte(content, '{')
+		if start < 0 {
+			return entries, nil
+		}
+		end := bytes.IndexByte(content[start:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("unterminated {")
+		}
+		block := content[start+1 : start+end]
+		content = content[start+end+1:]
+		if len(block) == 0 {
+			continue
+		}
+
+		var name []byte // pieces of name containing ", " so far
+		for _, piece := range bytes.Split(block, []byte(", ")) {
+			item := append(name, piece...)
+			if bytes.IndexByte(piece, '=') < 0 {
+				name = append(item, ", "...)
+				continue
+			}
+			e, err := parseBrcEntry(item)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, e)
+			name = nil
+		}
+		if name != nil {
+			return nil, fmt.Errorf("bad entry %q", bytes.TrimSuffix(name, []byte(", ")))
+		}
+	}
+}
+
+// parseBrcEntry parses `name=min/mean/max` entry, name is up to the last '='
+func parseBrcEntry(item []byte) (brcEntry, error) {
+	eq := bytes.LastIndexByte(item, '=')
+	values := bytes.Split(item[eq+1:], []byte{'/'})
+	if len(values) != 3 {
+		return brcEntry{}, fmt.Errorf("bad entry %q: expected min/mean/max", item)
+	}
+	var parsed [3]float64
+	for i, v := range values {
+		var err error
+		if parsed[i], err = strconv.ParseFloat(string(v), 64); err != nil {
+			return brcEntry{}, fmt.Errorf("bad entry %q: %w", item, err)
+		}
+	}
+	return brcEntry{
+		key: string(item[:eq]),
+		agg: Agg{min: parsed[0], sum: parsed[1], count: 1, max: parsed[2]},
+	}, nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestCanonicalize(t *testing.T) {
+	setFlags(t, "-canonicalize-output")
+	a := writeFile(t, "a.txt", "{Oslo=-5.0/1.0/7.0, Paris=2.0/10.0/20.0}\n")
+	// concatenated results with Paris in both blocks
+	b := writeFile(t, "b.txt", "{Paris=-1.0/4.0/15.0}{Rome=3.0/3.5/4.0, Paris=5.0/8.0/25.0}")
+
+	var w bytes.Buffer
+	got := formatResults(canonicalize([]string{a, b}, &w), "brc")
+	// Paris: min -1.0, max 25.0, mean (10+4+8)/3
+	if want := "{Oslo=-5.0/1.0/7.0, Paris=-1.0/7.3/25.0, Rome=3.0/3.5/4.0}"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	if !strings.Contains(w.String(), "[Paris]") {
+		t.Errorf("no warning about Paris: %q", w.String())
+	}
+
+	w.Reset()
+	canonicalize([]string{a}, &w)
+	if w.Len() != 0 {
+		t.Errorf("warning without duplicates: %q", w.String())
+	}
+}
+
+func TestParseBrc(t *testing.T) {
+	for _, tt := range []struct {
+		content string
+		keys    []string
+		err     string
+	}{
+		{content: "{}", keys: nil},
+		{content: "{A=1.0/2.0/3.0}\n{B=-1.0/0.0/1.0}", keys: []string{"A", "B"}},
+		{content: "{a=b=1.0/2.0/3.0, C=0.0/0.0/0.0}", keys: []string{"a=b", "C"}},
+		{content: "{A=1.0/2.0/3.0, Washington, D.C.=4.0/5.0/6.0, a, b, c=0.0/0.0/0.0}", keys: []string{"A", "Washington, D.C.", "a, b, c"}},
+		{content: "{A, B}", err: `bad entry "A, B"`},
+		{content: "{A=1.0/2.0/3.0", err: "unterminated {"},
+		{content: "{A}", err: `bad entry "A"`},
+		{content: "{A=1.0/2.0}", err: "expected min/mean/max"},
+		{content: "{A=1.0/x/3.0}", err: `bad entry "A=1.0/x/3.0"`},
+	} {
+		entries, err := parseBrc([]byte(tt.content))
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%q: got error %v, want %q", tt.content, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %s", tt.content, err)
+			continue
+		}
+		var keys []string
+		for _, e := range entries {
+			keys = append(keys, e.key)
+		}
+		if strings.Join(keys, ",") != strings.Join(tt.keys, ",") {
+			t.Errorf("%q: got keys %q, want %q", tt.content, keys, tt.keys)
+		}
+	}
+}
+
+func TestMergeTolerance(t *testing.T) {
+	// the same shard aggregated on two machines: mean of Paris rounded the other way
+	a := writeFile(t, "a.txt", "{Oslo=-5.0/1.0/7.0, Paris=2.0/10.1/20.0}")
+	b := writeFile(t, "b.txt", "{Paris=2.0/10.0/20.0, Rome=3.0/3.5/4.0}")
+	for _, tt := range []struct {
+		tolerance string
+		want      string
+		err       string
+	}{
+		// the first entry is kept
+		{tolerance: "0.1", want: "{Oslo=-5.0/1.0/7.0, Paris=2.0/10.1/20.0, Rome=3.0/3.5/4.0}"},
+		{tolerance: "0.5", want: "{Oslo=-5.0/1.0/7.0, Paris=2.0/10.1/20.0, Rome=3.0/3.5/4.0}"},
+		{tolerance: "0.05", err: b + ": Paris=2.0/10.0/20.0 differs from earlier entry 2.0/10.1/20.0 by 0.1, more than -merge-tolerance"},
+		{tolerance: "0", err: "by 0.1, more than -merge-tolerance"},
+	} {
+		setFlags(t, "-canonicalize-output", "-merge-tolerance", tt.tolerance)
+		var w bytes.Buffer
+		if tt.err != "" {
+			if msg := panicMessage(t, func() { canonicalize([]string{a, b}, &w) }); !strings.Contains(msg, tt.err) {
+				t.Errorf("%s: got %q, want %q", tt.tolerance, msg, tt.err)
+			}
+			continue
+		}
+		if got := formatResults(canonicalize([]string{a, b}, &w), "brc"); got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.tolerance, got, tt.want)
+		}
+		// overlapping shards are not averaged, so there is nothing to warn about
+		if w.Len() != 0 {
+			t.Errorf("%s: got warning %q", tt.tolerance, w.String())
+		}
+	}
+}
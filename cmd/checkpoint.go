@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const checkpointHeader = "1brc checkpoint"
+
+// scanWithCheckpoints processes data by segments of opts.checkpointEvery bytes.
+// After each segment merged result and offset of the next unprocessed record are saved
+// to opts.checkpoint, so long run can be continued with opts.resume after crash
+func scanWithCheckpoints(data []byte, workers int) map[string]Agg {
+	merged := make(map[string]Agg, stationsHint())
+	offset := 0
+	if opts.resume != "" {
+		merged, offset = loadCheckpoint(opts.resume, len(data))
+	}
+
+	for offset < len(data) && !stopped() {
+		end := nextRecord(data, min(offset+opts.checkpointEvery, len(data)))
+		results := mapScan(data[offset:end], scan, workers)
+		merged = reduce(append([]map[string]Agg{merged}, results...)...)
+		offset = end
+
+		if opts.checkpoint != "" {
+			saveCheckpoint(opts.checkpoint, merged, offset, len(data))
+		}
+	}
+
+	return merged
+}
+
+// saveCheckpoint writes checkpoint as
+//
+//	1brc checkpoint
+//	size <input size>
+//	offset <offset>
+//	<station>\t<sum>\t<count>\t<min>\t<max>\t<sumLog>\t<sumSq>\t<sumRecip>\t<wMean>\t<m2>\t<ema>\t<first>\t<bytes>\t<counts>\t<recent>\t<presence>
+//
+// where counts are value:count pairs separated by comma, empty unless -trimmed-mean or -iqr is set,
+// recent are the last values of -recent separated by comma and presence is -presence bitmap.
+// floats are written with full precision, so loaded aggregates are exactly the same
+func saveCheckpoint(path string, data map[string]Agg, offset int, size int) {
+	writeFileAtomic(path, false, func(w io.Writer) {
+		bw := bufio.NewWriter(w)
+		fmt.Fprintf(bw, "%s\nsize %d\noffset %d\n", checkpointHeader, size, offset)
+		for key, v := range data {
+			fmt.Fprintf(bw, "%s\t%s\t%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%d\t%s\t%s\t%d\n", key,
+				strconv.FormatFloat(v.sum, 'g', -1, 64), v.count,
+				strconv.FormatFloat(v.min, 'g', -1, 64),
+				strconv.FormatFloat(v.max, 'g', -1, 64),
+				strconv.FormatFloat(v.sumLog, 'g', -1, 64),
+				strconv.FormatFloat(v.sumSq, 'g', -1, 64),
+				strconv.FormatFloat(v.sumRecip, 'g', -1, 64),
+				strconv.FormatFloat(v.wMean, 'g', -1, 64),
+				strconv.FormatFloat(v.m2, 'g', -1, 64),
+				strconv.FormatFloat(v.ema, 'g', -1, 64),
+				strconv.FormatFloat(v.first, 'g', -1, 64),
+				v.bytes,
+				formatCounts(v.counts),
+				formatRecent(v.recent),
+				v.presence,
+			)
+		}
+		if err := bw.Flush(); err != nil {
+			panic(err)
+		}
+	})
+}
+
+// loadCheckpoint reads checkpoint saved by saveCheckpoint.
+// size is used to make sure checkpoint belongs to the same input
+func loadCheckpoint(path string, size int) (map[string]Agg, int) {
+	f, err := os.Open(path)
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+
+	var (
+		// not bufio.Scanner: counts of a station with many distinct values make lines over its 64KB limit
+		br      = bufio.NewReader(f)
+		header  []string
+		out     = make(map[string]Agg)
+		lineNum int
+	)
+	for {
+		line, err := br.ReadString('\n')
+		if err == io.EOF && line == "" {
+			break
+		}
+		if err != nil && err != io.EOF {
+			panic(err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		lineNum++
+		if lineNum <= 3 {
+			header = append(header, line)
+			continue
+		}
+
+		fields := strings.Split(line, "\t")
+		if len(fields) != 16 {
+			panic(fmt.Errorf("%s:%d: expected 16 fields, got %d", path, lineNum, len(fields)))
+		}
+		var (
+			agg  Agg
+			errs [15]error
+		)
+		agg.sum, errs[0] = strconv.ParseFloat(fields[1], 64)
+		agg.count, errs[1] = strconv.Atoi(fields[2])
+		agg.min, errs[2] = strconv.ParseFloat(fields[3], 64)
+		agg.max, errs[3] = strconv.ParseFloat(fields[4], 64)
+		agg.sumLog, errs[4] = strconv.ParseFloat(fields[5], 64)
+		agg.sumSq, errs[5] = strconv.ParseFloat(fields[6], 64)
+		agg.sumRecip, errs[6] = strconv.ParseFloat(fields[7], 64)
+		agg.wMean, errs[7] = strconv.ParseFloat(fields[8], 64)
+		agg.m2, errs[8] = strconv.ParseFloat(fields[9], 64)
+		agg.ema, errs[9] = strconv.ParseFloat(fields[10], 64)
+		agg.first, errs[10] = strconv.ParseFloat(fields[11], 64)
+		agg.bytes, errs[11] = strconv.Atoi(fields[12])
+		agg.counts, errs[12] = parseCounts(fields[13])
+		agg.recent, errs[13] = parseRecent(fields[14])
+		agg.presence, errs[14] = strconv.ParseUint(fields[15], 10, 64)
+		for _, err := range errs {
+			if err != nil {
+				panic(fmt.Errorf("%s:%d: %w", path, lineNum, err))
+			}
+		}
+		out[fields[0]] = agg
+	}
+
+	var savedSize, offset int
+	if len(header) != 3 || header[0] != checkpointHeader {
+		panic(fmt.Errorf("%s is not a checkpoint file", path))
+	}
+	if _, err := fmt.Sscanf(header[1], "size %d", &savedSize); err != nil {
+		panic(fmt.Errorf("%s: bad size: %w", path, err))
+	}
+	if _, err := fmt.Sscanf(header[2], "offset %d", &offset); err != nil {
+		panic(fmt.Errorf("%s: bad offset: %w", path, err))
+	}
+	if savedSize != size {
+		panic(fmt.Errorf("%s was made for input of %d bytes, got %d bytes", path, savedSize, size))
+	}
+
+	return out, offset
+}
+
+// formatCounts writes counts of distinct values as value:count pairs separated by comma
+func formatCounts(counts map[float64]int) string {
+	var sb strings.Builder
+	for value, n := range counts {
+		if sb.Len() > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(strconv.FormatFloat(value, 'g', -1, 64))
+		sb.WriteByte(':')
+		sb.WriteString(strconv.Itoa(n))
+	}
+	return sb.String()
+}
+
+// parseCounts reads counts written by formatCounts, nil for empty string
+func parseCounts(s string) (map[float64]int, error) {
+	if s == "" {
+		return nil, nil
+	}
+	pairs := strings.Split(s, ",")
+	counts := make(map[float64]int, len(pairs))
+	for _, pair := range pairs {
+		value, n, ok := strings.Cut(pair, ":")
+		if !ok {
+			return nil, fmt.Errorf("bad count %q", pair)
+		}
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, err
+		}
+		counts[v], err = strconv.Atoi(n)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return counts, nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func TestResumeFromCheckpoint(t *testing.T) {
+	data := genMeasurements(30000, 50)
+	const every = "100000" // bytes, input is split into 5 segments
+	flags := []string{"-checkpoint-every", every, "-iqr", "-variance", "-recent", "3"}
+	path := filepath.Join(t.TempDir(), "run.checkpoint")
+
+	setFlags(t, append(flags, "-checkpoint", path)...)
+	full := scanWithCheckpoints(data, 1)
+
+	// crash after the first segment: checkpoint is what the run saved by then
+	offset := nextRecord(data, opts.checkpointEvery)
+	saveCheckpoint(path, reduce(map[string]Agg{}, scan(data, 0, offset)), offset, len(data))
+
+	setFlags(t, append(flags, "-resume", path)...)
+	resumed := scanWithCheckpoints(data, 1)
+
+	// segments are the same, so aggregates are exactly the same, not just after rounding
+	var want, got bytes.Buffer
+	dumpMap(full, &want)
+	dumpMap(resumed, &got)
+	if got.String() != want.String() {
+		t.Errorf("resumed run differs from full run:\n%s\nwant\n%s", got.String(), want.String())
+	}
+	if got, want := formatResults(resumed, "csv"), formatResults(full, "csv"); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+	for key, v := range full {
+		if r := resumed[key].recent; formatRecent(r) != formatRecent(v.recent) {
+			t.Errorf("%s: recent values %s, want %s", key, formatRecent(r), formatRecent(v.recent))
+		}
+	}
+}
+
+func TestResumeLongCountsLine(t *testing.T) {
+	// 20000 distinct values of one station, its counts are ~200KB line of checkpoint
+	var b bytes.Buffer
+	for i := 0; i < 20000; i++ {
+		fmt.Fprintf(&b, "A;%d.5\n", i)
+	}
+	data := b.Bytes()
+	flags := []string{"-checkpoint-every", "100000", "-iqr"}
+	path := filepath.Join(t.TempDir(), "run.checkpoint")
+
+	setFlags(t, flags...)
+	full := scanWithCheckpoints(data, 1)
+
+	offset := nextRecord(data, len(data)/2)
+	saveCheckpoint(path, reduce(map[string]Agg{}, scan(data, 0, offset)), offset, len(data))
+	setFlags(t, append(flags, "-resume", path)...)
+	resumed := scanWithCheckpoints(data, 1)
+
+	if got, want := len(resumed["A"].counts), len(full["A"].counts); got != want {
+		t.Errorf("got %d distinct values, want %d", got, want)
+	}
+	if got, want := formatResults(resumed, "csv"), formatResults(full, "csv"); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestLoadCheckpointOfOtherInput(t *testing.T) {
+	setFlags(t)
+	path := filepath.Join(t.TempDir(), "run.checkpoint")
+	saveCheckpoint(path, map[string]Agg{"A": newAgg(1)}, 6, 12)
+	msg := panicMessage(t, func() { loadCheckpoint(path, 13) })
+	if want := path + " was made for input of 12 bytes, got 13 bytes"; msg != want {
+		t.Errorf("got %q, want %q", msg, want)
+	}
+}
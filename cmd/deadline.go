@@ -0,0 +1,26 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// exitPartial is exit status of run stopped by -max-runtime, its output has results aggregated so far
+const exitPartial = 3
+
+// runCtx is cancelled when -max-runtime is over
+var runCtx = context.Background()
+
+// partial is set once some input is left unprocessed because of -max-runtime
+var partial atomic.Bool
+
+// stopped reports whether -max-runtime is over, caller has to stop taking more input then.
+// It's checked between chunks (and lines in -window mode), so run takes up to a chunk longer,
+// and a read blocked on input (e.g. idle stdin) is not interrupted
+func stopped() bool {
+	if runCtx.Err() == nil {
+		return false
+	}
+	partial.Store(true)
+	return true
+}
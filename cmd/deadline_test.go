@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// cancelReader cancels run after the first n bytes are read
+type cancelReader struct {
+	r      *strings.Reader
+	n      int
+	cancel context.CancelFunc
+}
+
+func (c *cancelReader) Read(p []byte) (int, error) {
+	if c.n <= 0 {
+		c.cancel()
+	}
+	n, err := c.r.Read(p[:min(len(p), max(c.n, 1))])
+	c.n -= n
+	return n, err
+}
+
+func TestStoppedStream(t *testing.T) {
+	setFlags(t, "-stream", "-stream-buffer", "60")
+	ctx, cancel := context.WithCancel(context.Background())
+	savedCtx := runCtx
+	runCtx = ctx
+	t.Cleanup(func() {
+		runCtx = savedCtx
+		partial.Store(false)
+	})
+
+	// chunks of 10 records, deadline is over while the second one is read
+	data := strings.Repeat("A;1.0\n", 100)
+	results := scanStream(&cancelReader{r: strings.NewReader(data), n: 90, cancel: cancel}, 1)
+	if got := countRows(results); got == 0 || got >= 100 {
+		t.Errorf("got %d rows, want part of 100", got)
+	}
+	if !partial.Load() {
+		t.Error("results are not marked partial")
+	}
+}
+
+func TestMaxRuntime(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "in.txt"), genMeasurements(200000, 100), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	out, code := runMain(t, dir, "-input", "in.txt", "-chunk-bytes", "4096", "-max-runtime", "1ns")
+	if code != exitPartial || !strings.Contains(out, "warning: -max-runtime 1ns is over, results are partial") {
+		t.Errorf("exit %d: %s", code, out)
+	}
+	// results aggregated so far are written anyway
+	if _, err := os.Stat(filepath.Join(dir, resultPath)); err != nil {
+		t.Error(err)
+	}
+
+	if out, code := runMain(t, dir, "-input", "in.txt", "-max-runtime", "1m"); code != 0 || strings.Contains(out, "partial") {
+		t.Errorf("exit %d: %s", code, out)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+// denseTable aggregates stations whose names are integer ids in [0, opts.denseKeys)
+// in a slice indexed by id, so their records cost no hashing
+type denseTable struct {
+	aggs  []Agg    // count is zero for ids not seen yet
+	names []string // names of seen ids, allocated once per station like map keys
+}
+
+func newDenseTable() *denseTable {
+	return &denseTable{
+		aggs:  make([]Agg, opts.denseKeys),
+		names: make([]string, opts.denseKeys),
+	}
+}
+
+// add accounts value of station key. It returns aggregate of the station,
+// or nil if key is not a dense id and has to go to the map
+func (t *denseTable) add(key []byte, value float64) *Agg {
+	id, ok := denseID(key, len(t.aggs))
+	if !ok {
+		return nil
+	}
+	agg := &t.aggs[id]
+	if agg.count == 0 {
+		*agg = newAgg(value)
+		t.names[id] = string(key)
+	} else {
+		agg.Add(value)
+	}
+	return agg
+}
+
+// denseID parses key as decimal id below limit. Ids with leading zeros ("007") are not dense,
+// their names differ from "7" and they must stay different stations
+func denseID(key []byte, limit int) (int, bool) {
+	if len(key) == 0 || len(key) > 1 && key[0] == '0' {
+		return 0, false
+	}
+	id := 0
+	for _, c := range key {
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+		id = id*10 + int(c-'0')
+		if id >= limit {
+			return 0, false
+		}
+	}
+	return id, true
+}
+
+// mergeInto adds seen stations to m, which has no dense ids (they never go to the map)
+func (t *denseTable) mergeInto(m map[string]Agg) {
+	for id, agg := range t.aggs {
+		if agg.count > 0 {
+			m[t.names[id]] = agg
+		}
+	}
+}
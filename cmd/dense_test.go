@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDenseKeys(t *testing.T) {
+	// ids, leading zeros, ids out of range and names together
+	data := "7;1.0\n007;2.0\n0;-1.5\n99;3.0\n100;4.0\nOslo;5.0\n7;-2.0\n-1;6.0\n0;0.5\n"
+	setFlags(t)
+	want := formatResults(aggregate(data, 1), "brc")
+	for _, workers := range []int{1, 3} {
+		setFlags(t, "-dense-keys", "100", "-chunk-bytes", "16")
+		if got := formatResults(aggregate(data, workers), "brc"); got != want {
+			t.Errorf("%d workers: got %s, want %s", workers, got, want)
+		}
+	}
+
+	setFlags(t, "-dense-keys", "100")
+	table := newDenseTable()
+	for _, key := range []string{"7", "0", "99", "7"} {
+		if table.add([]byte(key), 1) == nil {
+			t.Errorf("%s is not dense", key)
+		}
+	}
+	for _, key := range []string{"007", "00", "100", "-1", "Oslo", "", "1a"} {
+		if table.add([]byte(key), 1) != nil {
+			t.Errorf("%q is dense", key)
+		}
+	}
+	if table.aggs[7].count != 2 || table.names[7] != "7" || table.aggs[1].count != 0 {
+		t.Errorf("got %+v and %+v", table.aggs[7], table.aggs[1])
+	}
+
+	// integer ids of generated data
+	ids := bytes.ReplaceAll(genMeasurements(10000, 500), []byte("Station"), nil)
+	setFlags(t)
+	want = formatResults(aggregate(string(ids), 1), "brc")
+	setFlags(t, "-dense-keys", "300")
+	if got := formatResults(aggregate(string(ids), 2), "brc"); got != want {
+		t.Error("dense results differ from map results")
+	}
+}
@@ -0,0 +1,22 @@
+package main
+
+// matchStation reports whether station name passes -include and -exclude
+func matchStation(key []byte) bool {
+	if opts.include != nil && !opts.include.Match(key) {
+		return false
+	}
+	return opts.exclude == nil || !opts.exclude.Match(key)
+}
+
+// stationFilter memoizes matchStation per station, so regexes run once per station
+// and every further record costs a map lookup (no allocation, see scan)
+type stationFilter map[string]bool
+
+func (f stationFilter) keep(key []byte) bool {
+	keep, ok := f[string(key)]
+	if !ok {
+		keep = matchStation(key)
+		f[string(key)] = keep
+	}
+	return keep
+}
@@ -0,0 +1,40 @@
+package main
+
+import "testing"
+
+func TestIncludeExclude(t *testing.T) {
+	const data = "Hamburg;12.0\nHalifax;5.5\nOslo;-3.0\nHamburg;-2.0\nOsaka;20.0\nBerlin;8.0\n"
+	for _, tt := range []struct {
+		args []string
+		want string
+	}{
+		{nil, "{Berlin=8.0/8.0/8.0, Halifax=5.5/5.5/5.5, Hamburg=-2.0/5.0/12.0, Osaka=20.0/20.0/20.0, Oslo=-3.0/-3.0/-3.0}"},
+		{[]string{"-include", "^Ha"}, "{Halifax=5.5/5.5/5.5, Hamburg=-2.0/5.0/12.0}"},
+		{[]string{"-exclude", "^Os"}, "{Berlin=8.0/8.0/8.0, Halifax=5.5/5.5/5.5, Hamburg=-2.0/5.0/12.0}"},
+		// exclude wins over include
+		{[]string{"-include", "^(Ha|Os)", "-exclude", "burg$|ka$"}, "{Halifax=5.5/5.5/5.5, Oslo=-3.0/-3.0/-3.0}"},
+		{[]string{"-include", "^Zz"}, "{}"},
+	} {
+		setFlags(t, tt.args...)
+		for _, workers := range []int{1, 3} {
+			if got := formatResults(aggregate(data, workers), "brc"); got != tt.want {
+				t.Errorf("%v, %d workers: got %s, want %s", tt.args, workers, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestStationFilter(t *testing.T) {
+	setFlags(t, "-include", "^A", "-exclude", "B")
+	f := stationFilter{}
+	for key, want := range map[string]bool{"Aa": true, "AB": false, "Ba": false, "": false} {
+		for i := 0; i < 2; i++ { // the second one is memoized
+			if got := f.keep([]byte(key)); got != want {
+				t.Errorf("%q: got %v, want %v", key, got, want)
+			}
+		}
+	}
+	if len(f) != 4 {
+		t.Errorf("got %d memoized stations, want 4", len(f))
+	}
+}
@@ -0,0 +1,770 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"text/template"
+	"time"
+
+	"golang.org/x/text/collate"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/language"
+)
+
+// options is a command line configuration
+type options struct {
+	roundingEpsilon float64
+	preview         int
+	quotedFields    bool
+	perWorkerStats  bool
+	format          string
+	noClobber       bool
+	delimiters      string
+	isDelimiter     [256]bool // lookup table built from delimiters
+	dumpMap         string
+	checkpoint      string
+	checkpointEvery int
+	resume          string
+	timeBucket      time.Duration
+	helpExamples    bool
+	input           string
+	window          time.Duration
+	locale          string
+	collator        *collate.Collator // built from locale
+	schema          string
+	chunkBytes      int
+	outputPaths     pathsFlag
+	outputs         []output // outputPaths with resolved formats
+	geomean         bool
+	keysFile        string
+	keys            []string // loaded from keysFile
+	confidence      float64
+	stream          bool
+	streamBuffer    int
+	global          bool
+	inputEncoding   string
+	decoder         *encoding.Decoder // built from inputEncoding
+	parallelWrite   int
+	missingValue    string
+	harmonic        bool
+	profileSummary  int
+	noProfile       bool
+	inputGlob       string
+	valueTransform  string
+	scale, offset   float64 // parsed from valueTransform
+	assertOutput    string
+	recordInlineSep string
+	inlineSep       byte // recordInlineSep, 0 if not set
+	minValue        float64
+	maxValue        float64
+	clamp           bool // minValue or maxValue is set
+	profileLabels   labelsFlag
+	emptyKey        string
+	canonicalize    bool
+	flushInterval   time.Duration
+	trimmedMean     float64
+	exactFloat      bool
+	balance         string
+	includeExpr     string
+	excludeExpr     string
+	include         *regexp.Regexp
+	exclude         *regexp.Regexp
+	filter          bool // include or exclude is set
+	stdoutJSONLines bool
+	collapseSpace   bool
+	rejects         string
+	rowsHint        int
+	ema             float64
+	monotonic       string
+	snapshotEvery   int
+	maxLineLength   int
+	iqr             bool
+	keepCounts      bool // Agg.counts are needed
+	templateFile    string
+	sharedTable     bool
+	byteStats       bool
+	buildIndex      string
+	useIndex        string
+	stations        []string
+	normMinusZero   bool
+	affinityReport  bool
+	prefetchBuffers int
+	variance        bool
+	maxRuntime      time.Duration
+	dumpWorkerMaps  string
+	mergeTolerance  float64
+	crossCheck      bool // mergeTolerance is set
+	sampleRate      float64
+	sample          bool   // sampleRate is below 1
+	sampleLimit     uint64 // hash threshold of sampled lines
+	hashKeys        bool
+	parseWorkers    int
+	aggWorkers      int
+	pipeline        bool // parseWorkers or aggWorkers is set
+	cv              bool
+	dumpBoundaries  bool
+	recent          int
+	rollup          string
+	rollupSep       string // parsed from rollup
+	valueScale      float64
+	scaleDivisor    float64 // 1/valueScale if it's a power of ten, 0 otherwise
+	serve           string
+	recompute       bool
+	denseKeys       int
+	presence        bool
+	fastOutput      bool
+	layout          string
+	valueFirst      bool // layout starts with value
+	template        *template.Template
+	strictRange     bool
+	rangeMin        float64
+	rangeMax        float64
+	skipBad         bool
+}
+
+var opts options
+
+// parseFlags fills opts from command line
+func parseFlags() {
+	flag.Float64Var(&opts.roundingEpsilon, "rounding-epsilon", 1e-9,
+		"nudge added before rounding to one decimal, keeps x.x5 means stable across worker counts")
+	flag.IntVar(&opts.preview, "preview", 0,
+		"print first N parsed records to stderr (as \"station -> value\") and exit")
+	flag.BoolVar(&opts.quotedFields, "quoted-fields", false,
+		"strip surrounding double quotes from station and value, e.g. \"Paris\";\"12.3\"")
+	flag.BoolVar(&opts.perWorkerStats, "per-worker-stats", false,
+		"print rows count and duration of each worker (to diagnose load imbalance)")
+	flag.BoolVar(&opts.strictRange, "strict-range", false,
+		"reject values outside of [-range-min, -range-max]")
+	flag.Float64Var(&opts.rangeMin, "range-min", -99.9, "lowest valid value for -strict-range")
+	flag.Float64Var(&opts.rangeMax, "range-max", 99.9, "highest valid value for -strict-range")
+	flag.BoolVar(&opts.skipBad, "skip-bad", false, "skip bad records instead of failing")
+	flag.StringVar(&opts.format, "format", "brc", "output format: "+formatNames())
+	flag.BoolVar(&opts.noClobber, "no-clobber", false,
+		"fail instead of overwriting existing output file, or any other file of the run "+
+			"(-recent, -dump-map, -rejects, ...) except "+cpuProfilePath+" and -checkpoint, which are rewritten")
+	flag.StringVar(&opts.delimiters, "delimiters", ";",
+		"set of accepted station/value delimiters, record is split on the first of them "+
+			"(station names containing any of them are split too)")
+	flag.StringVar(&opts.dumpMap, "dump-map", "",
+		"write raw aggregates (sum, count, min, max) per station to file, without rounding")
+	flag.StringVar(&opts.checkpoint, "checkpoint", "",
+		"periodically save merged partial result and processed offset to file")
+	flag.IntVar(&opts.checkpointEvery, "checkpoint-every", 1<<30, "bytes of input processed between checkpoints")
+	flag.StringVar(&opts.resume, "resume", "", "continue processing from checkpoint file")
+	flag.DurationVar(&opts.timeBucket, "time-bucket", 0,
+		"bucket size for timestamp;station;value input, aggregates per (bucket, station) under bucket|station keys")
+	flag.BoolVar(&opts.helpExamples, "help-examples", false, "print usage examples and exit")
+	flag.StringVar(&opts.input, "input", dataPath, "input file, - for stdin, .tar.gz archives are read member by member")
+	flag.DurationVar(&opts.window, "window", 0,
+		"stream timestamp;station;value records and aggregate only the last window of stream time")
+	flag.StringVar(&opts.locale, "locale", "",
+		"sort stations by collation rules of locale (e.g. de, fr-CA) instead of bytes order")
+	flag.StringVar(&opts.schema, "schema", "",
+		"write output fields (in order, with types) of chosen -format to file, - for stderr")
+	flag.IntVar(&opts.chunkBytes, "chunk-bytes", 16<<20,
+		"size of chunks dispatched to workers, 0 splits input evenly between workers")
+	flag.Var(&opts.outputPaths, "output",
+		"output file ("+resultPath+" by default), format is inferred from extension (e.g. .json) unless -format is set. "+
+			"Repeatable, the same results are written to every file")
+	flag.BoolVar(&opts.geomean, "geomean", false,
+		"output geometric mean per station (not in brc format), values must be positive or skipped with -skip-bad")
+	flag.StringVar(&opts.keysFile, "keys-file", "",
+		"file with station per line, only they are printed in file order (absent ones as N/A)")
+	flag.Float64Var(&opts.confidence, "confidence", 0,
+		"output confidence interval for the mean at this level, e.g. 0.95 (not in brc format)")
+	flag.BoolVar(&opts.stream, "stream", false,
+		"read input by -stream-buffer chunks instead of loading it whole (bounded memory, works with endless stdin)")
+	flag.IntVar(&opts.streamBuffer, "stream-buffer", 64<<20, "size of input buffer for -stream")
+	flag.BoolVar(&opts.global, "global", false, "add "+globalKey+" entry aggregated over all readings of all stations")
+	flag.StringVar(&opts.inputEncoding, "input-encoding", "",
+		"encoding of station names (e.g. latin1, windows-1251, shift_jis), they are transcoded to UTF-8")
+	flag.IntVar(&opts.parallelWrite, "parallel-write", 0,
+		"shard sorted stations into N output files written concurrently (e.g. result.part0.txt), plus a manifest. "+
+			"Every part is a complete file of -format, extra parts of an earlier run are removed")
+	flag.StringVar(&opts.missingValue, "missing-value", "error",
+		"what to do with records without value (e.g. \"Paris;\"): skip, zero or error")
+	flag.BoolVar(&opts.harmonic, "harmonic", false,
+		"output harmonic mean per station (not in brc format), zero values fail or are skipped with -skip-bad")
+	flag.IntVar(&opts.profileSummary, "profile-summary", 0,
+		"print top N functions by CPU time from "+cpuProfilePath+" to stderr after run")
+	flag.StringVar(&opts.inputGlob, "input-glob", "",
+		"process all files matching glob (e.g. 'data/*.txt') and merge results, instead of -input")
+	flag.StringVar(&opts.valueTransform, "value-transform", "",
+		"linear transform applied to each parsed value before checks and aggregation, e.g. 'v*0.1+5'")
+	flag.StringVar(&opts.assertOutput, "assert-output", "",
+		"compare formatted output with expected file instead of writing it, exit 1 with diff on mismatch")
+	flag.StringVar(&opts.recordInlineSep, "record-inline-sep", "",
+		"separator of several records in one line, e.g. ',' for Paris:12.3,Oslo:-1.0 (with -delimiters :)")
+	flag.Float64Var(&opts.minValue, "min-value", math.Inf(-1), "clamp lower values to this one (they're not skipped)")
+	flag.Float64Var(&opts.maxValue, "max-value", math.Inf(1), "clamp higher values to this one (they're not skipped)")
+	flag.BoolVar(&opts.noProfile, "no-profile", false,
+		"don't write "+cpuProfilePath+" (runs of -recompute-on-signal after the first one are started with it)")
+	flag.Var(&opts.profileLabels, "profile-label",
+		"key=value label of "+cpuProfilePath+" samples, to tell profiles apart (repeatable)")
+	flag.StringVar(&opts.emptyKey, "empty-key", "keep",
+		"what to do with records without station name (e.g. \";12.3\"): skip, keep (aggregate under empty name) or error")
+	flag.BoolVar(&opts.canonicalize, "canonicalize-output", false,
+		"merge brc result files given as arguments (or -input) into one sorted entry per station")
+	flag.DurationVar(&opts.flushInterval, "flush-interval", 0,
+		"rewrite output with intermediate results at most once per interval in -stream and -window modes (0 writes only at the end)")
+	flag.Float64Var(&opts.trimmedMean, "trimmed-mean", 0,
+		"output mean without this fraction of the lowest and the highest values per station, e.g. 0.1 (not in brc format)")
+	flag.BoolVar(&opts.exactFloat, "deterministic-float-parse", false,
+		"parse values rounded exactly like strconv.ParseFloat, bit-identical on every platform")
+	flag.StringVar(&opts.balance, "balance", "bytes",
+		"how input is split into chunks: bytes (equal size) or rows (equal number of records, costs a counting pass)")
+	flag.StringVar(&opts.includeExpr, "include", "",
+		"aggregate only stations whose name matches regexp (raw bytes, before -input-encoding)")
+	flag.StringVar(&opts.excludeExpr, "exclude", "",
+		"skip stations whose name matches regexp (raw bytes, before -input-encoding)")
+	flag.BoolVar(&opts.stdoutJSONLines, "stdout-jsonl", false,
+		"also stream JSON object per station per line to stdout, flushed per line (run info goes to stderr then)")
+	flag.BoolVar(&opts.collapseSpace, "collapse-whitespace", false,
+		"replace runs of whitespace in station names with single space, e.g. \"New   York\" is \"New York\"")
+	flag.StringVar(&opts.rejects, "rejects", "",
+		"write records skipped by -skip-bad, -missing-value skip or -empty-key skip to file, as they are in input")
+	flag.IntVar(&opts.rowsHint, "rows-hint", 0,
+		fmt.Sprintf("approximate number of rows, station maps are presized for as many stations (at most %d)", maxStations))
+	flag.Float64Var(&opts.ema, "ema", 0,
+		"output exponential moving average per station with this smoothing factor, e.g. 0.1 (not in brc format). "+
+			"It depends on input order, so it's exact only with GOMAXPROCS=1")
+	flag.StringVar(&opts.monotonic, "validate-monotonic-timestamps", "",
+		"fail on the first timestamp;station;value record which is older than the previous one: global or station (per station order)")
+	flag.IntVar(&opts.snapshotEvery, "snapshot-every", 0,
+		"in -stream and -window modes write results every N rows to <output>.snapshot<ext>, keeping the previous one with .1 suffix")
+	flag.IntVar(&opts.maxLineLength, "max-line-length", 0,
+		"fail on record longer than this many bytes (corrupt data without delimiter or newline), skip it with -skip-bad")
+	flag.BoolVar(&opts.iqr, "iqr", false,
+		"output interquartile range (p75 - p25) per station (not in brc format)")
+	flag.StringVar(&opts.templateFile, "output-template-file", "",
+		"text/template file for \"template\" format (default with this flag), rendered per station with fields "+
+			"like {{.station}}, optional {{define \"header\"}} and {{define \"footer\"}} get {{.Stations}} and {{.Fields}}")
+	flag.BoolVar(&opts.sharedTable, "shared-table", false,
+		"aggregate into one table shared by all workers (sharded mutexes) instead of per-worker maps merged at the end")
+	flag.BoolVar(&opts.byteStats, "byte-stats", false,
+		"output number of input bytes taken by records of every station (not in brc format)")
+	flag.StringVar(&opts.buildIndex, "build-index", "",
+		"save byte ranges of records of every station to index file, for later -use-index queries")
+	flag.StringVar(&opts.useIndex, "use-index", "",
+		"read only records of -stations using index file made by -build-index for the same input")
+	flag.Func("stations", "comma separated stations to query with -use-index", func(s string) error {
+		opts.stations = strings.Split(s, ",")
+		return nil
+	})
+	flag.BoolVar(&opts.normMinusZero, "normalize-minus-zero-input", false,
+		"parse \"-0.0\" values as 0.0, so negative zero doesn't get into min/max of -dump-map and checkpoints "+
+			"(rounded outputs print 0.0 anyway)")
+	flag.BoolVar(&opts.affinityReport, "affinity-report", false,
+		"print CPUs and OS threads every worker ran on, sampled per chunk (linux only)")
+	flag.IntVar(&opts.prefetchBuffers, "prefetch-buffers", 1,
+		"number of -stream-buffer buffers, with more than one next chunks are read in background while workers process the current one")
+	flag.BoolVar(&opts.variance, "variance", false,
+		"output sample variance per station (not in brc format), computed with Welford's algorithm")
+	flag.StringVar(&opts.layout, "layout", "",
+		"order of fields and their separator, e.g. 'value:key' for 12.3:Paris records (instead of -delimiters)")
+	flag.DurationVar(&opts.maxRuntime, "max-runtime", 0,
+		"stop taking more input after this time and write results aggregated so far, "+
+			"marked partial by a warning and exit status 3 (0 means no limit)")
+	flag.StringVar(&opts.dumpWorkerMaps, "dump-worker-maps", "",
+		"write raw aggregates of every worker before reduce to files in this directory, like -dump-map")
+	flag.Float64Var(&opts.mergeTolerance, "merge-tolerance", 0,
+		"with -canonicalize-output treat stations found in several files as overlapping shards of the same data: "+
+			"keep one entry if their min/mean/max differ at most by this much, fail otherwise. "+
+			"Tolerance is in output units: values are compared as written, rounded to one decimal, "+
+			"so e.g. 0.1 allows one rounding step")
+	flag.Float64Var(&opts.sampleRate, "sample-rate", 1,
+		"aggregate only this fraction of lines (chosen by hash of line) and extrapolate counts, for fast estimates. "+
+			"Means are approximate, min and max are of the sample")
+	flag.BoolVar(&opts.hashKeys, "hash-keys", false,
+		"replace station names in output with first 16 hex digits of their SHA-256 (after -input-encoding). "+
+			"Hashes are unsalted, so names from a known list can still be matched")
+	flag.IntVar(&opts.parseWorkers, "parse-workers", 0,
+		"number of goroutines parsing chunks into records for -aggregate-workers, "+
+			"setting either of them splits workers into the two stages (default GOMAXPROCS then)")
+	flag.IntVar(&opts.aggWorkers, "aggregate-workers", 0,
+		"number of goroutines aggregating records parsed by -parse-workers, each owns stations by hash of name")
+	flag.BoolVar(&opts.cv, "cv", false,
+		"output coefficient of variation (sample stddev / |mean|) per station with four decimals (not in brc format), "+
+			"undefined for zero mean")
+	flag.BoolVar(&opts.dumpBoundaries, "dump-boundaries", false,
+		"print chunks input is split into for workers and verify they cover it record by record "+
+			"without gaps or overlaps, then exit (status 1 on violations)")
+	flag.IntVar(&opts.recent, "recent", 0,
+		"write the last N values of every station (not of -global and -rollup entries) to "+recentPath+". "+
+			"They depend on input order, so they are exact only with GOMAXPROCS=1")
+	flag.StringVar(&opts.rollup, "rollup", "",
+		"also aggregate groups of stations by name prefix, e.g. 'prefix:/' adds France/ entry "+
+			"for France/Paris and France/Lyon")
+	flag.Float64Var(&opts.valueScale, "value-scale", 0,
+		"values are integers in units of this step, e.g. 0.1 for 123 meaning 12.3 (decimal point is an error)")
+	flag.StringVar(&opts.serve, "serve", "",
+		"after run serve results in -format over plain TCP at this address (e.g. :9000) to every client that connects, "+
+			"until killed")
+	flag.BoolVar(&opts.recompute, "recompute-on-signal", false,
+		"after run keep process waiting for SIGHUP, then aggregate the whole input again and rewrite outputs "+
+			"(for a growing file)")
+	flag.IntVar(&opts.denseKeys, "dense-keys", 0,
+		"stations are mostly integer ids in [0, N): aggregate them in a slice indexed by id instead of a map "+
+			"(other names still go to the map)")
+	flag.BoolVar(&opts.presence, "presence", false,
+		"output which input shards (files of -input-glob or members of .tar.gz, at most 64) "+
+			"have records of every station, e.g. 101 (not in brc format)")
+	flag.BoolVar(&opts.fastOutput, "fast-output", false,
+		"format brc output with strconv.AppendFloat into a reused buffer instead of fmt.Sprintf per station "+
+			"(the same bytes, no allocation per station)")
+	flag.Parse()
+
+	if opts.prefetchBuffers < 1 {
+		usageError("-prefetch-buffers must be positive")
+	}
+	if (opts.buildIndex != "" || opts.useIndex != "") && (!wholeInput() || opts.input == "-") {
+		usageError("-build-index and -use-index work only with single input file read as a whole")
+	}
+	if opts.useIndex != "" && len(opts.stations) == 0 {
+		usageError("-use-index needs -stations to query")
+	}
+	if opts.useIndex != "" && opts.sharedTable {
+		usageError("-use-index can't be combined with -shared-table")
+	}
+	opts.keepCounts = opts.trimmedMean > 0 || opts.iqr
+	if opts.maxLineLength < 0 {
+		usageError("-max-line-length must not be negative")
+	}
+	if opts.snapshotEvery < 0 {
+		usageError("-snapshot-every must not be negative")
+	}
+	switch opts.monotonic {
+	case "", "global", "station":
+	default:
+		usageError("unknown -validate-monotonic-timestamps mode %q", opts.monotonic)
+	}
+	if opts.monotonic != "" && !wholeInput() {
+		usageError("-validate-monotonic-timestamps works only with single input read as a whole")
+	}
+	if opts.sharedTable && !wholeInput() {
+		usageError("-shared-table works only with single input read as a whole")
+	}
+	if opts.ema < 0 || opts.ema > 1 {
+		usageError("-ema must be in [0, 1]")
+	}
+	if opts.rowsHint < 0 {
+		usageError("-rows-hint must not be negative")
+	}
+	if opts.stdoutJSONLines {
+		diag = os.Stderr
+	}
+	if opts.includeExpr != "" {
+		var err error
+		if opts.include, err = regexp.Compile(opts.includeExpr); err != nil {
+			usageError("bad -include: %s", err)
+		}
+	}
+	if opts.excludeExpr != "" {
+		var err error
+		if opts.exclude, err = regexp.Compile(opts.excludeExpr); err != nil {
+			usageError("bad -exclude: %s", err)
+		}
+	}
+	opts.filter = opts.include != nil || opts.exclude != nil
+	switch opts.balance {
+	case "bytes", "rows":
+	default:
+		usageError("unknown -balance %q", opts.balance)
+	}
+	if opts.trimmedMean < 0 || opts.trimmedMean >= 0.5 {
+		usageError("-trimmed-mean must be in [0, 0.5)")
+	}
+	if opts.flushInterval < 0 {
+		usageError("-flush-interval must not be negative")
+	}
+	if opts.flushInterval > 0 && opts.noClobber {
+		usageError("-flush-interval rewrites output, it can't be used with -no-clobber")
+	}
+
+	switch opts.emptyKey {
+	case "skip", "keep", "error":
+	default:
+		usageError("unknown -empty-key policy %q", opts.emptyKey)
+	}
+	opts.clamp = isFlagSet("min-value") || isFlagSet("max-value")
+	if opts.minValue > opts.maxValue {
+		usageError("-min-value is greater than -max-value")
+	}
+	if len(opts.recordInlineSep) > 1 {
+		usageError("-record-inline-sep must be a single byte")
+	}
+	if opts.recordInlineSep != "" {
+		opts.inlineSep = opts.recordInlineSep[0]
+	}
+	if _, ok := formats[opts.format]; opts.assertOutput != "" && !ok {
+		usageError("-assert-output doesn't support %s format", opts.format)
+	}
+	if opts.valueTransform != "" {
+		var err error
+		opts.scale, opts.offset, err = parseTransform(opts.valueTransform)
+		if err != nil {
+			usageError("bad -value-transform: %s", err)
+		}
+	}
+	switch opts.missingValue {
+	case "skip", "zero", "error":
+	default:
+		usageError("unknown -missing-value policy %q", opts.missingValue)
+	}
+	if opts.parallelWrite > 1 && opts.keysFile != "" {
+		usageError("-parallel-write can't be combined with -keys-file")
+	}
+	if opts.inputEncoding != "" {
+		enc, err := htmlindex.Get(opts.inputEncoding)
+		if err != nil {
+			usageError("bad -input-encoding: %s", err)
+		}
+		opts.decoder = enc.NewDecoder()
+	}
+	if opts.streamBuffer <= 0 {
+		usageError("-stream-buffer must be positive")
+	}
+	if opts.confidence < 0 || opts.confidence >= 1 {
+		usageError("-confidence must be in [0, 1)")
+	}
+	if opts.keysFile != "" {
+		opts.keys = readKeysFile(opts.keysFile)
+		if opts.hashKeys {
+			for i, key := range opts.keys {
+				opts.keys[i] = hashKey(key)
+			}
+		}
+	}
+	if opts.locale != "" {
+		tag, err := language.Parse(opts.locale)
+		if err != nil {
+			usageError("bad -locale: %s", err)
+		}
+		opts.collator = collate.New(tag)
+	}
+	if opts.checkpointEvery <= 0 {
+		usageError("-checkpoint-every must be positive")
+	}
+	if opts.layout != "" {
+		if isFlagSet("delimiters") {
+			usageError("-layout can't be combined with -delimiters, it sets the separator itself")
+		}
+		var sep byte
+		var ok bool
+		opts.valueFirst, sep, ok = parseLayout(opts.layout)
+		if !ok {
+			usageError("bad -layout %q, expected key<sep>value or value<sep>key with single byte separator", opts.layout)
+		}
+		opts.delimiters = string(sep)
+	}
+	if opts.sampleRate <= 0 || opts.sampleRate > 1 {
+		usageError("-sample-rate must be in (0, 1]")
+	}
+	opts.sample = opts.sampleRate < 1
+	opts.sampleLimit = sampleLimit(opts.sampleRate)
+	if opts.sample && opts.monotonic != "" {
+		usageError("-sample-rate can't be combined with -validate-monotonic-timestamps, which needs every record")
+	}
+	opts.pipeline = opts.parseWorkers != 0 || opts.aggWorkers != 0
+	if opts.pipeline {
+		if opts.parseWorkers < 0 || opts.aggWorkers < 0 {
+			usageError("-parse-workers and -aggregate-workers must not be negative")
+		}
+		if opts.parseWorkers == 0 {
+			opts.parseWorkers = runtime.GOMAXPROCS(0)
+		}
+		if opts.aggWorkers == 0 {
+			opts.aggWorkers = runtime.GOMAXPROCS(0)
+		}
+		switch {
+		case opts.timeBucket > 0, opts.monotonic != "":
+			usageError("-parse-workers and -aggregate-workers don't support timestamped records yet")
+		case opts.sharedTable:
+			usageError("-parse-workers and -aggregate-workers can't be combined with -shared-table")
+		case opts.denseKeys > 0:
+			usageError("-parse-workers and -aggregate-workers can't be combined with -dense-keys")
+		case opts.perWorkerStats || opts.affinityReport || opts.dumpWorkerMaps != "":
+			usageError("-per-worker-stats, -affinity-report and -dump-worker-maps track mapScan workers, " +
+				"they don't work with -parse-workers and -aggregate-workers")
+		}
+	}
+	if opts.rollup != "" {
+		var err error
+		if opts.rollupSep, err = parseRollup(opts.rollup); err != nil {
+			usageError("bad -rollup: %s", err)
+		}
+	}
+	if isFlagSet("value-scale") {
+		if opts.valueScale == 0 || math.IsInf(opts.valueScale, 0) || math.IsNaN(opts.valueScale) {
+			usageError("-value-scale must be a finite non-zero number")
+		}
+		opts.scaleDivisor = scaleDivisor(opts.valueScale)
+	}
+	if opts.serve != "" {
+		if _, ok := formats[opts.format]; !ok {
+			usageError("-serve doesn't support %s format", opts.format)
+		}
+		if opts.assertOutput != "" {
+			usageError("-serve can't be combined with -assert-output")
+		}
+	}
+	if opts.noProfile && opts.profileSummary > 0 {
+		usageError("-profile-summary needs profile, it can't be combined with -no-profile")
+	}
+	if opts.recompute {
+		switch {
+		case opts.input == "-" && opts.inputGlob == "" && flag.NArg() == 0:
+			usageError("-recompute-on-signal needs input which can be read again, not stdin")
+		case opts.noClobber:
+			usageError("-recompute-on-signal can't be combined with -no-clobber, it rewrites outputs")
+		case opts.serve != "":
+			usageError("-recompute-on-signal can't be combined with -serve")
+		case opts.maxRuntime > 0:
+			usageError("-recompute-on-signal can't be combined with -max-runtime")
+		case opts.assertOutput != "":
+			usageError("-recompute-on-signal can't be combined with -assert-output")
+		}
+	}
+	if opts.presence && opts.inputGlob == "" && !isTarGz(opts.input) {
+		usageError("-presence works only with -input-glob or .tar.gz input, which consist of shards")
+	}
+	if opts.denseKeys < 0 {
+		usageError("-dense-keys must not be negative")
+	}
+	if opts.recent < 0 {
+		usageError("-recent must not be negative")
+	}
+	if opts.dumpBoundaries && !wholeInput() {
+		usageError("-dump-boundaries works only with single input read as a whole")
+	}
+	opts.crossCheck = isFlagSet("merge-tolerance")
+	if opts.crossCheck && !opts.canonicalize {
+		usageError("-merge-tolerance works only with -canonicalize-output")
+	}
+	if opts.mergeTolerance < 0 {
+		usageError("-merge-tolerance must not be negative")
+	}
+	if opts.dumpWorkerMaps != "" && opts.sharedTable {
+		usageError("-dump-worker-maps can't be combined with -shared-table, workers have no maps of their own")
+	}
+	if opts.perWorkerStats && opts.sharedTable {
+		usageError("-per-worker-stats can't be combined with -shared-table, rows are counted in maps of workers")
+	}
+	if opts.delimiters == "" {
+		usageError("-delimiters must not be empty")
+	}
+	for i := 0; i < len(opts.delimiters); i++ {
+		opts.isDelimiter[opts.delimiters[i]] = true
+	}
+
+	if opts.templateFile != "" && !isFlagSet("format") {
+		opts.format = "template"
+	}
+	if len(opts.outputPaths) == 0 {
+		opts.outputPaths = pathsFlag{resultPath}
+	}
+	if opts.parallelWrite > 1 && len(opts.outputPaths) > 1 {
+		usageError("-parallel-write supports only one -output")
+	}
+	for _, path := range opts.outputPaths {
+		out := output{path: path, format: opts.format}
+		if !isFlagSet("format") {
+			if format, ok := formatExts[filepath.Ext(path)]; ok {
+				out.format = format
+			}
+		}
+		if !isFormat(out.format) {
+			usageError("unknown format %q", out.format)
+		}
+		opts.outputs = append(opts.outputs, out)
+	}
+	// format of the first output is the one for -schema and -assert-output
+	opts.format = opts.outputs[0].format
+	for _, out := range opts.outputs {
+		if out.format == "template" && opts.template == nil {
+			if opts.templateFile == "" {
+				usageError("template format needs -output-template-file")
+			}
+			var err error
+			if opts.template, err = loadTemplate(opts.templateFile); err != nil {
+				usageError("bad -output-template-file: %s", err)
+			}
+		}
+	}
+}
+
+// wholeInput reports whether input is a single file (or stdin) read into memory at once,
+// i.e. without -stream, -window, -checkpoint, -resume, -input-glob, positional files or tar.gz
+func wholeInput() bool {
+	return !opts.stream && opts.window == 0 && opts.checkpoint == "" && opts.resume == "" &&
+		opts.inputGlob == "" && flag.NArg() == 0 && !isTarGz(opts.input)
+}
+
+// output is a file results are written to
+type output struct {
+	path   string
+	format string
+}
+
+// pathsFlag collects repeated flag values
+type pathsFlag []string
+
+func (p *pathsFlag) String() string {
+	return strings.Join(*p, ",")
+}
+
+func (p *pathsFlag) Set(s string) error {
+	*p = append(*p, s)
+	return nil
+}
+
+// labelsFlag collects repeated key=value flags as pprof.Labels arguments
+type labelsFlag []string
+
+func (l *labelsFlag) String() string {
+	var pairs []string
+	for i := 0; i+1 < len(*l); i += 2 {
+		pairs = append(pairs, (*l)[i]+"="+(*l)[i+1])
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (l *labelsFlag) Set(s string) error {
+	key, value, ok := strings.Cut(s, "=")
+	if !ok || key == "" {
+		return errors.New("expected key=value")
+	}
+	*l = append(*l, key, value)
+	return nil
+}
+
+// diag is where run info (CPUs, timings, worker stats) is printed,
+// stderr when stdout is taken by -stdout-jsonl
+var diag io.Writer = os.Stdout
+
+// isFlagSet reports whether flag was given on command line
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+const examples = `Examples:
+
+  # check how first records of ./data/measurements.txt are parsed
+  brc -preview 5 -delimiters ';,' -quoted-fields
+
+  # records with value first, e.g. "12.3:Paris"
+  brc -layout value:key
+
+  # per-country totals next to stations named like "France/Paris"
+  brc -rollup prefix:/ -format table
+
+  # human readable table instead of brc line
+  brc -format table
+
+  # quartiles spread and mean without 10% of extreme values per station
+  brc -iqr -trimmed-mean 0.1 -format table
+
+  # report layout from text/template file, e.g. "{{.station}}: {{.min}}..{{.max}}"
+  brc -output-template-file report.tmpl -output report.md
+
+  # metric per line, for results kept under version control
+  brc -format flat -output results/latest.txt
+
+  # the same results as JSON and CSV at once, formats are inferred from extensions
+  brc -output result.json -output result.csv
+
+  # huge result sharded into 4 JSON files written concurrently, listed in result.manifest
+  brc -parallel-write 4 -output result.json
+
+  # fail on values outside of [-50, 50] or skip them
+  brc -strict-range -range-min -50 -range-max 50
+  brc -strict-range -skip-bad
+
+  # skip corrupt records longer than 1KB instead of scanning for their end
+  brc -max-line-length 1024 -skip-bad
+
+  # keep skipped records for later inspection
+  brc -strict-range -skip-bad -rejects rejects.txt
+
+  # stations as JSON lines for a consumer reading the pipe
+  brc -stdout-jsonl | consumer
+
+  # only stations starting with San, except ports
+  brc -include '^San' -exclude 'port$'
+
+  # exponential moving average in file order next to the mean
+  GOMAXPROCS=1 brc -ema 0.1 -format table
+
+  # hourly aggregates of timestamp;station;value records
+  brc -time-bucket 1h
+
+  # fail on the first record out of time order of its station
+  brc -validate-monotonic-timestamps station -time-bucket 1h
+
+  # aggregate only the last hour of timestamp;station;value stream from stdin
+  producer | brc -input - -window 1h
+
+  # same, with result.txt refreshed every 5 seconds while stream goes on
+  producer | brc -input - -window 1h -flush-interval 5s
+
+  # file split into parts at arbitrary bytes, read as one stream in order
+  brc measurements.part1 measurements.part2
+
+  # several files merged into one result
+  brc -input-glob 'data/*.txt'
+
+  # input which doesn't fit in memory, read by 64MB chunks
+  zcat measurements.txt.gz | brc -input - -stream
+
+  # same, the next chunk is read while the current one is processed
+  zcat measurements.txt.gz | brc -input - -stream -prefetch-buffers 2
+
+  # results of several runs, concatenated, as one sorted entry per station
+  brc -canonicalize-output -output merged.txt result1.txt result2.txt
+
+  # merge results of shards which may overlap, computed on different machines
+  brc -canonicalize-output -merge-tolerance 0.1 -output merged.txt shard1.txt shard2.txt
+
+  # progress of a long stream in result.snapshot.txt every 100M rows
+  zcat measurements.txt.gz | brc -input - -stream -snapshot-every 100000000
+
+  # repeated queries of a few stations over the same big file
+  brc -build-index measurements.idx
+  brc -use-index measurements.idx -stations Hamburg,Paris
+
+  # long run which can be continued after crash
+  brc -checkpoint run.checkpoint
+  brc -resume run.checkpoint -checkpoint run.checkpoint
+
+  # keep existing result.txt untouched
+  brc -no-clobber
+
+  # answer within 30s, with partial results (exit status 3) if input is not done by then
+  brc -max-runtime 30s
+
+  # keep running over a growing file, recompute on kill -HUP <pid>
+  brc -recompute-on-signal
+`
+
+// usageError reports invalid command line and exits
+func usageError(format string, args ...any) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	flag.Usage()
+	os.Exit(2)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// flusher rewrites output with intermediate results of a long stream,
+// at most once per opts.flushInterval however often data arrives
+type flusher struct {
+	last time.Time
+}
+
+// flushes is shared by all streaming paths, so interval holds across tar members too
+var flushes = &flusher{last: time.Now()}
+
+// maybe writes current results if opts.flushInterval passed since the previous write.
+// Checked when data arrives rather than by a background goroutine: results
+// change only then, and aggregates don't have to be locked
+func (f *flusher) maybe(results func() map[string]Agg) {
+	if opts.flushInterval <= 0 || time.Since(f.last) < opts.flushInterval {
+		return
+	}
+	// prepareResults may add global key, scan keeps merging into the original map
+	writeResultsToFile(prepareResults(maps.Clone(results())))
+	f.last = time.Now()
+}
+
+// snapshotter writes intermediate results of a long stream every opts.snapshotEvery rows
+// into snapshotPath, the previous snapshot is kept with .1 suffix.
+// Unlike checkpoint it can't be resumed from, but it's a cheap way to see progress
+// and not to lose everything on crash
+type snapshotter struct {
+	next int // rows of the next snapshot
+}
+
+var snapshots = &snapshotter{}
+
+// maybe writes snapshot if rows (processed so far) reached the next multiple of opts.snapshotEvery.
+// It's checked between chunks, so snapshot is taken at the first chunk end after the multiple
+func (s *snapshotter) maybe(rows int, results func() map[string]Agg) {
+	if opts.snapshotEvery <= 0 || rows < max(s.next, opts.snapshotEvery) {
+		return
+	}
+	out := opts.outputs[0]
+	path := snapshotPath(out.path)
+	if _, err := os.Stat(path); err == nil {
+		if err := os.Rename(path, path+".1"); err != nil {
+			panic(err)
+		}
+	}
+	snapshot := prepareResults(maps.Clone(results()))
+	writeResults(snapshot, sortedKeys(snapshot), path, out.format)
+	fmt.Fprintf(diag, "snapshot of %d rows written to %s\n", rows, path)
+	s.next = (rows/opts.snapshotEvery + 1) * opts.snapshotEvery
+}
+
+// snapshotPath returns path of snapshots of output, e.g. result.snapshot.txt for result.txt
+func snapshotPath(output string) string {
+	ext := filepath.Ext(output)
+	return strings.TrimSuffix(output, ext) + ".snapshot" + ext
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFlushInterval(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "result.txt")
+	setFlags(t, "-flush-interval", "50ms", "-output", path)
+
+	f := &flusher{last: time.Now()}
+	results := map[string]Agg{}
+	writes := 0
+	start := time.Now()
+	for i := 0; time.Since(start) < 275*time.Millisecond; i++ {
+		results["A"] = newAgg(float64(i))
+		f.maybe(func() map[string]Agg {
+			writes++
+			return results
+		})
+		time.Sleep(time.Millisecond)
+	}
+	elapsed := time.Since(start)
+
+	// data arrives every millisecond, output is rewritten once per interval at most
+	if limit := int(elapsed / (50 * time.Millisecond)); writes == 0 || writes > limit {
+		t.Errorf("%d writes in %s, want 1..%d", writes, elapsed, limit)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestFlushDisabled(t *testing.T) {
+	setFlags(t)
+	f := &flusher{}
+	f.maybe(func() map[string]Agg {
+		t.Fatal("results are written without -flush-interval")
+		return nil
+	})
+}
+
+func TestSnapshotEvery(t *testing.T) {
+	dir := t.TempDir()
+	output := filepath.Join(dir, "result.txt")
+	// chunks of 10 records: buffer holds 10 records of 6 bytes
+	setFlags(t, "-stream", "-stream-buffer", "60", "-snapshot-every", "25", "-output", output)
+	saved := snapshots
+	snapshots = &snapshotter{}
+	t.Cleanup(func() { snapshots = saved })
+	out := captureDiag(t)
+
+	var data strings.Builder
+	for i := 0; i < 100; i++ {
+		station := "A"
+		if i >= 90 {
+			station = "B"
+		}
+		fmt.Fprintf(&data, "%s;%d.0\n", station, i%10)
+	}
+	scanStream(strings.NewReader(data.String()), 1)
+
+	// taken at the first chunk end after every multiple of 25 rows
+	want := "" +
+		"snapshot of 30 rows written to " + filepath.Join(dir, "result.snapshot.txt") + "\n" +
+		"snapshot of 50 rows written to " + filepath.Join(dir, "result.snapshot.txt") + "\n" +
+		"snapshot of 80 rows written to " + filepath.Join(dir, "result.snapshot.txt") + "\n" +
+		"snapshot of 100 rows written to " + filepath.Join(dir, "result.snapshot.txt") + "\n"
+	if out.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", out, want)
+	}
+	// the latest snapshot and the one before it
+	for path, want := range map[string]string{
+		"result.snapshot.txt":   "{A=0.0/4.5/9.0, B=0.0/4.5/9.0}",
+		"result.snapshot.txt.1": "{A=0.0/4.5/9.0}",
+	} {
+		if got, _ := os.ReadFile(filepath.Join(dir, path)); string(got) != want {
+			t.Errorf("%s: got %q, want %q", path, got, want)
+		}
+	}
+}
+
+func TestSnapshotPath(t *testing.T) {
+	for output, want := range map[string]string{
+		"result.txt":     "result.snapshot.txt",
+		"out/r.json":     "out/r.snapshot.json",
+		"result":         "result.snapshot",
+		"a.b/result.csv": "a.b/result.snapshot.csv",
+	} {
+		if got := snapshotPath(output); got != want {
+			t.Errorf("%s: got %s, want %s", output, got, want)
+		}
+	}
+}
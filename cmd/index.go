@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const indexHeader = "1brc index"
+
+// buildIndex collects byte ranges of records of every station in data,
+// adjacent records of the same station are coalesced into one range.
+// So index is small for files clustered by station, and has a range per record
+// for shuffled ones (like generated 1BRC input)
+func buildIndex(data []byte) map[string][][2]int {
+	index := make(map[string][][2]int, stationsHint())
+	var (
+		key     []byte
+		start   int
+		scratch []byte
+	)
+	for i := 0; i < len(data); {
+		start = i
+		if opts.timeBucket > 0 || opts.monotonic != "" {
+			_, i = parseTimestamp(data, i)
+		}
+		key, _, i = parseRecord(data, i, &scratch)
+
+		ranges := index[string(key)]
+		if n := len(ranges); n > 0 && ranges[n-1][1] == start {
+			ranges[n-1][1] = i
+		} else {
+			ranges = append(ranges, [2]int{start, i})
+		}
+		index[string(key)] = ranges
+	}
+	return index
+}
+
+// saveIndex writes index of input of size bytes as
+//
+//	1brc index
+//	size <input size>
+//	<station>\t<from>+<length>,<from delta>+<length>,...
+//
+// where starts of ranges after the first one are relative to the end of the previous range
+func saveIndex(path string, index map[string][][2]int, size int) {
+	writeFileAtomic(path, opts.noClobber, func(w io.Writer) {
+		bw := bufio.NewWriter(w)
+		fmt.Fprintf(bw, "%s\nsize %d\n", indexHeader, size)
+		for key, ranges := range index {
+			bw.WriteString(key)
+			bw.WriteByte('\t')
+			prev := 0
+			for i, r := range ranges {
+				if i > 0 {
+					bw.WriteByte(',')
+				}
+				bw.WriteString(strconv.Itoa(r[0] - prev))
+				bw.WriteByte('+')
+				bw.WriteString(strconv.Itoa(r[1] - r[0]))
+				prev = r[1]
+			}
+			bw.WriteByte('\n')
+		}
+		if err := bw.Flush(); err != nil {
+			panic(err)
+		}
+	})
+}
+
+// loadIndex reads ranges of stations from index saved by saveIndex,
+// lines of other stations are skipped without parsing.
+// size is used to make sure index belongs to the same input
+func loadIndex(path string, size int, stations []string) map[string][][2]int {
+	f, err := os.Open(path)
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+
+	wanted := make(map[string]bool, len(stations))
+	for _, s := range stations {
+		wanted[s] = true
+	}
+
+	br := bufio.NewReader(f)
+	header, _ := br.ReadString('\n')
+	sizeLine, _ := br.ReadString('\n')
+	if header != indexHeader+"\n" {
+		panic(fmt.Errorf("%s is not an index file", path))
+	}
+	var savedSize int
+	if _, err := fmt.Sscanf(sizeLine, "size %d", &savedSize); err != nil {
+		panic(fmt.Errorf("%s: bad size: %w", path, err))
+	}
+	if savedSize != size {
+		panic(fmt.Errorf("%s was made for input of %d bytes, got %d bytes, rebuild it with -build-index", path, savedSize, size))
+	}
+
+	out := make(map[string][][2]int, len(stations))
+	for {
+		line, err := br.ReadString('\n')
+		if err == io.EOF {
+			return out
+		}
+		if err != nil {
+			panic(err)
+		}
+		key, list, ok := strings.Cut(strings.TrimSuffix(line, "\n"), "\t")
+		if !ok {
+			panic(fmt.Errorf("%s: bad line %q", path, line))
+		}
+		if !wanted[key] {
+			continue
+		}
+
+		var ranges [][2]int
+		prev := 0
+		for _, item := range strings.Split(list, ",") {
+			from, length, ok := strings.Cut(item, "+")
+			delta, err1 := strconv.Atoi(from)
+			n, err2 := strconv.Atoi(length)
+			if !ok || err1 != nil || err2 != nil {
+				panic(fmt.Errorf("%s: bad range %q of %q", path, item, key))
+			}
+			ranges = append(ranges, [2]int{prev + delta, prev + delta + n})
+			prev += delta + n
+		}
+		out[key] = ranges
+	}
+}
+
+// scanIndexed reads only records of opts.stations listed in index opts.useIndex
+// and aggregates them, the rest of input isn't read at all
+func scanIndexed(path string, workers int) map[string]Agg {
+	f, err := os.Open(path)
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		panic(err)
+	}
+	size := int(stat.Size())
+
+	index := loadIndex(opts.useIndex, size, opts.stations)
+	total := 0
+	for _, ranges := range index {
+		for _, r := range ranges {
+			total += r[1] - r[0]
+		}
+	}
+
+	// records of all ranges one after another, each range ends with the end of record
+	data := make([]byte, 0, total+1)
+	for _, ranges := range index {
+		for _, r := range ranges {
+			// last record may end with newline which was added after the end of file
+			to := min(r[1], size)
+			n := len(data)
+			data = data[:n+to-r[0]]
+			if _, err := f.ReadAt(data[n:], int64(r[0])); err != nil {
+				panic(err)
+			}
+			data = terminateLastLine(data)
+		}
+	}
+	return reduce(mapScan(data, scan, workers)...)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestIndex(t *testing.T) {
+	// clustered runs of stations, then shuffled records, the last one without newline
+	const input = "A;1.0\nA;2.0\nA;3.0\nB;10.0\nB;20.0\nC  c;-5.0\nA;-1.0\nB;30.0\nC c;5.0\nA;9.5"
+	dir := t.TempDir()
+	path := filepath.Join(dir, "measurements.txt")
+	if err := os.WriteFile(path, []byte(input), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	indexPath := filepath.Join(dir, "measurements.idx")
+
+	setFlags(t, "-collapse-whitespace")
+	data := terminateLastLine([]byte(input))
+	index := buildIndex(data)
+	// adjacent records of a station are one range
+	if got := index["A"]; len(got) != 3 || got[0] != [2]int{0, 18} {
+		t.Errorf("got ranges of A %v", got)
+	}
+	if got := len(index["C c"]); got != 2 {
+		t.Errorf("got %d ranges of C c, want 2", got)
+	}
+	saveIndex(indexPath, index, len(input))
+	// building index doesn't change input scanned after it
+	want := formatResults(prepareResults(reduce(mapScan(data, scan, 2)...)), "brc")
+	if full := formatResults(aggregate(input, 2), "brc"); want != full {
+		t.Errorf("got %s after -build-index, want %s", want, full)
+	}
+
+	for _, tt := range []struct {
+		stations string
+		want     string
+	}{
+		{"A", "{A=-1.0/2.9/9.5}"},
+		{"B", "{B=10.0/20.0/30.0}"},
+		{"A,C c", "{A=-1.0/2.9/9.5, C c=-5.0/0.0/5.0}"},
+		{"Nowhere", "{}"},
+	} {
+		setFlags(t, "-collapse-whitespace", "-input", path, "-use-index", indexPath, "-stations", tt.stations)
+		if got := formatResults(scanIndexed(path, 2), "brc"); got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.stations, got, tt.want)
+		}
+	}
+}
+
+func TestIndexOfOtherInput(t *testing.T) {
+	setFlags(t)
+	indexPath := filepath.Join(t.TempDir(), "measurements.idx")
+	saveIndex(indexPath, buildIndex([]byte("A;1.0\n")), 6)
+
+	msg := panicMessage(t, func() { loadIndex(indexPath, 7, []string{"A"}) })
+	if want := indexPath + " was made for input of 6 bytes, got 7 bytes, rebuild it with -build-index"; msg != want {
+		t.Errorf("got %q, want %q", msg, want)
+	}
+	msg = panicMessage(t, func() { loadIndex(writeFile(t, "other.txt", "A;1.0\n"), 6, []string{"A"}) })
+	if want := "is not an index file"; !strings.Contains(msg, want) {
+		t.Errorf("got %q, want %q", msg, want)
+	}
+}
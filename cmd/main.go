@@ -1,162 +1,658 @@
 package main
 
 import (
-	"errors"
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"os"
+	"path/filepath"
 	"runtime"
 	"runtime/pprof"
 	"sort"
+	"strings"
 	"sync"
 	"time"
+
+	"golang.org/x/text/encoding"
 )
 
-const keySize = 50
+const (
+	dataPath   = "./data/measurements.txt"
+	resultPath = "result.txt"
+
+	cpuProfilePath = "cpu.prof"
+)
 
 type Agg struct {
-	sum   float64
-	count int
-	min   float64
-	max   float64
+	sum    float64
+	count  int
+	min    float64
+	max    float64
+	sumLog float64 // sum of log(value), for -geomean
+	sumSq  float64 // sum of value^2, for variance based aggregates
+
+	sumRecip float64 // sum of 1/value, for -harmonic
+
+	// running mean and sum of squared deviations from it (Welford), for -variance
+	wMean float64
+	m2    float64
+
+	// exponential moving average in input order and the first value it was seeded with, for -ema
+	ema   float64
+	first float64
+
+	bytes int // input bytes of records (with timestamp and terminator), for -byte-stats
+
+	// counts of distinct values, for -trimmed-mean and -iqr. Memory grows with number
+	// of distinct values per station, not with rows (at most 1999 for one decimal in [-99.9, 99.9])
+	counts map[float64]int
+
+	// last values in input order, for -recent. Pointer keeps Agg within 128 bytes,
+	// bigger map values are allocated one by one
+	recent *recentValues
+
+	presence uint64 // bit per input shard the station has records in, for -presence
 }
 
-func main() {
+// newAgg returns aggregate of single value
+func newAgg(value float64) Agg {
+	agg := Agg{min: value, max: value}
+	agg.Add(value)
+	return agg
+}
 
-	// Create and open a file to write the CPU profile to
-	cpuProfile, err := os.Create("cpu.prof")
-	if err != nil {
-		log.Fatal("Could not create CPU profile: ", err)
+// Add accounts value in aggregate
+func (a *Agg) Add(value float64) {
+	a.min = min(a.min, value)
+	a.max = max(a.max, value)
+	a.sum += value
+	a.count++
+	a.sumSq += value * value
+	if opts.geomean {
+		a.sumLog += math.Log(value)
 	}
-	defer cpuProfile.Close()
+	if opts.harmonic {
+		a.sumRecip += 1 / value
+	}
+	if opts.variance {
+		delta := value - a.wMean
+		a.wMean += delta / float64(a.count)
+		a.m2 += delta * (value - a.wMean)
+	}
+	if opts.ema > 0 {
+		if a.count == 1 {
+			a.ema, a.first = value, value
+		} else {
+			a.ema += opts.ema * (value - a.ema)
+		}
+	}
+	if opts.keepCounts {
+		if a.counts == nil {
+			a.counts = make(map[float64]int)
+		}
+		a.counts[value]++
+	}
+	if opts.recent > 0 {
+		if a.recent == nil {
+			a.recent = &recentValues{}
+		}
+		a.recent.add(value)
+	}
+}
 
-	// Start the CPU profiling
-	if err := pprof.StartCPUProfile(cpuProfile); err != nil {
-		log.Fatal("Could not start CPU profile: ", err)
+// Merge accounts other aggregate (e.g. of another chunk) in aggregate
+// EMA and recent values are correct only if other follows aggregate in input order
+func (a *Agg) Merge(other Agg) {
+	if opts.recent > 0 && other.count > 0 {
+		a.recent = a.recent.merge(other.recent)
+	}
+	if opts.ema > 0 && other.count > 0 {
+		if a.count == 0 {
+			a.ema, a.first = other.ema, other.first
+		} else {
+			// other.ema is seeded with other.first, continue it from a.ema instead
+			a.ema = other.ema + math.Pow(1-opts.ema, float64(other.count))*(a.ema-other.first)
+		}
 	}
+	if opts.variance && other.count > 0 {
+		// parallel combination of Welford states (Chan et al.), both sides may be empty
+		na, nb := float64(a.count), float64(other.count)
+		n := na + nb
+		delta := other.wMean - a.wMean
+		a.wMean += delta * nb / n
+		a.m2 += other.m2 + delta*delta*na*nb/n
+	}
+	a.sum += other.sum
+	a.min = min(a.min, other.min)
+	a.max = max(a.max, other.max)
+	a.count += other.count
+	a.sumLog += other.sumLog
+	a.sumSq += other.sumSq
+	a.sumRecip += other.sumRecip
+	a.bytes += other.bytes
+	a.presence |= other.presence
+	if other.counts != nil {
+		if a.counts == nil {
+			a.counts = make(map[float64]int, len(other.counts))
+		}
+		for value, n := range other.counts {
+			a.counts[value] += n
+		}
+	}
+}
 
-	// Ensure the CPU profile is stopped when the function returns
-	defer pprof.StopCPUProfile()
+func (a Agg) mean() float64 {
+	return a.sum / float64(a.count)
+}
 
-	t0 := time.Now()
-	run()
-	fmt.Printf("took %s\n", time.Now().Sub(t0))
+// variance returns sample variance, NaN for single value.
+// With -variance it's taken from Welford state, which doesn't lose precision
+// when values are large relative to their spread
+func (a Agg) variance() float64 {
+	if a.count < 2 {
+		return math.NaN()
+	}
+	n := float64(a.count)
+	if opts.variance {
+		return a.m2 / (n - 1)
+	}
+	// sumSq - sum^2/n may go slightly below zero due to cancellation
+	return max(a.sumSq-a.sum*a.sum/n, 0) / (n - 1)
 }
 
-func run() {
+// confidenceInterval returns interval for the mean at confidence level (e.g. 0.95),
+// using normal approximation
+func (a Agg) confidenceInterval(confidence float64) (low, high float64) {
+	z := math.Sqrt2 * math.Erfinv(confidence)
+	margin := z * math.Sqrt(a.variance()/float64(a.count))
+	return a.mean() - margin, a.mean() + margin
+}
 
-	data := readData()
+// cv returns coefficient of variation: sample stddev relative to absolute value of the mean.
+// NaN if mean is zero (or there is single value)
+func (a Agg) cv() float64 {
+	mean := a.mean()
+	if mean == 0 {
+		return math.NaN()
+	}
+	return math.Sqrt(a.variance()) / math.Abs(mean)
+}
 
-	workers := runtime.GOMAXPROCS(0)
+// trimmedMean returns mean of values without fraction of the lowest and the highest ones
+func (a Agg) trimmedMean(fraction float64) float64 {
+	values := a.distinctValues()
+	trim := int(float64(a.count) * fraction)
+	var (
+		sum  float64
+		seen int // values before current one, in sorted order
+	)
+	for _, value := range values {
+		n := a.counts[value]
+		// part of n copies within [trim, count-trim)
+		kept := min(seen+n, a.count-trim) - max(seen, trim)
+		if kept > 0 {
+			sum += value * float64(kept)
+		}
+		seen += n
+	}
+	return sum / float64(a.count-2*trim)
+}
 
-	results := mapScan(data, scan, workers)
+// distinctValues returns sorted values of counts
+func (a Agg) distinctValues() []float64 {
+	values := make([]float64, 0, len(a.counts))
+	for value := range a.counts {
+		values = append(values, value)
+	}
+	sort.Float64s(values)
+	return values
+}
 
-	mergedResults := reduce(results...)
+// quantile returns q-th quantile of values, interpolated linearly between
+// the closest ranks (same as numpy and R default)
+func (a Agg) quantile(values []float64, q float64) float64 {
+	h := float64(a.count-1) * q
+	lo := int(h)
+	low, high := a.orderStat(values, lo), a.orderStat(values, min(lo+1, a.count-1))
+	return low + (h-float64(lo))*(high-low)
+}
 
-	writeResultsToFile(mergedResults)
+// orderStat returns k-th smallest value (from 0) of sorted distinct values
+func (a Agg) orderStat(values []float64, k int) float64 {
+	seen := 0
+	for _, value := range values {
+		seen += a.counts[value]
+		if k < seen {
+			return value
+		}
+	}
+	return math.NaN()
+}
 
+// iqr returns interquartile range p75 - p25
+func (a Agg) iqr() float64 {
+	values := a.distinctValues()
+	return a.quantile(values, 0.75) - a.quantile(values, 0.25)
 }
 
-// scan reads chunk of data without extra allocations
-func scan(data []byte, i int, end int) map[string]Agg {
-	m := make(map[[keySize]byte]Agg, 0)
-	var (
-		key           [keySize]byte
-		keyPos        int
-		keyPrevLength int // keyPrevLength used to clean (set 0x0) for bytes that are garbage for new key
+func main() {
+	parseFlags()
 
-		value      float64
-		valueStart int
+	if opts.helpExamples {
+		fmt.Fprint(os.Stderr, examples)
+		return
+	}
 
-		agg Agg
-		ok  bool
-	)
+	if opts.schema == "-" {
+		printSchema(opts.format, os.Stderr)
+	} else if opts.schema != "" {
+		writeFileAtomic(opts.schema, opts.noClobber, func(w io.Writer) {
+			printSchema(opts.format, w)
+		})
+	}
+
+	if opts.preview > 0 {
+		preview(opts.preview, os.Stderr)
+		return
+	}
 
-	// skip not full part
-	if i != 0 {
-		for data[i] != '\n' {
-			i++
+	if opts.dumpBoundaries {
+		if !checkBoundaries(readData(opts.input), runtime.GOMAXPROCS(0), os.Stdout) {
+			os.Exit(1)
 		}
-		i++
+		return
 	}
 
-	for i < end {
-		// parse key
-		for data[i] != ';' {
-			key[keyPos] = data[i]
-			i++
-			keyPos++
+	if opts.canonicalize {
+		paths := flag.Args()
+		if len(paths) == 0 {
+			paths = []string{opts.input}
 		}
-		i++
+		writeResultsToFile(canonicalize(paths, os.Stderr))
+		return
+	}
 
-		// clean rest of key
-		for j := keyPos; j < keyPrevLength; j++ {
-			key[j] = 0x0
+	if !opts.noProfile {
+		// Create and open a file to write the CPU profile to
+		cpuProfile, err := os.Create(cpuProfilePath)
+		if err != nil {
+			log.Fatal("Could not create CPU profile: ", err)
 		}
+		defer cpuProfile.Close()
 
-		keyPrevLength = keyPos
-		keyPos = 0
+		// Start the CPU profiling
+		if err := pprof.StartCPUProfile(cpuProfile); err != nil {
+			log.Fatal("Could not start CPU profile: ", err)
+		}
+
+		// Ensure the CPU profile is stopped when the function returns
+		defer pprof.StopCPUProfile()
+	}
 
-		// parse value
-		valueStart = i
-		for data[i] != '\n' {
-			i++
+	if opts.maxRuntime > 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(context.Background(), opts.maxRuntime)
+		defer cancel()
+	}
+
+	var hup <-chan os.Signal
+	if opts.recompute {
+		hup = notifyRecompute()
+	}
+
+	t0 := time.Now()
+	var results map[string]Agg
+	// labels are inherited by goroutines started inside, so they mark all samples of the run
+	pprof.Do(context.Background(), pprof.Labels(opts.profileLabels...), func(context.Context) {
+		results = run()
+	})
+	fmt.Fprintf(diag, "took %s\n", time.Now().Sub(t0))
+
+	if partial.Load() {
+		fmt.Fprintf(os.Stderr, "warning: -max-runtime %s is over, results are partial\n", opts.maxRuntime)
+		if opts.serve == "" {
+			pprof.StopCPUProfile()
+			os.Exit(exitPartial)
 		}
-		value = fastFloat(data[valueStart:i])
-		i++
+	}
+
+	if opts.profileSummary > 0 {
+		pprof.StopCPUProfile()
+		printProfileSummary(cpuProfilePath, opts.profileSummary, os.Stderr)
+	}
+
+	if opts.serve != "" {
+		pprof.StopCPUProfile() // server runs until killed, so deferred stop never happens
+		serveResults(opts.serve, results)
+	}
+	if opts.recompute {
+		pprof.StopCPUProfile() // profile of the first run only, recomputes run with -no-profile
+		recomputeOnSignal(hup)
+	}
+}
+
+// run processes input and writes outputs, returns results as they were written
+func run() map[string]Agg {
 
-		// update value
-		agg, ok = m[key]
-		if ok {
-			agg.min = min(agg.min, value)
-			agg.max = max(agg.max, value)
-			agg.sum = agg.sum + value
-			agg.count++
+	if opts.noClobber {
+		for _, path := range noClobberPaths() {
+			if _, err := os.Stat(path); err == nil {
+				log.Fatalf("%s already exists, refusing to overwrite it (-no-clobber)", path)
+			}
+		}
+	}
+
+	workers := runtime.GOMAXPROCS(0)
+	fmt.Fprintf(diag, "%d CPUs\n", workers)
+	if opts.ema > 0 && workers > 1 {
+		fmt.Fprintln(os.Stderr, "warning: chunks are merged out of input order with several workers, -ema is approximate (use GOMAXPROCS=1)")
+	}
+	if opts.recent > 0 && workers > 1 {
+		fmt.Fprintln(os.Stderr, "warning: chunks are merged out of input order with several workers, "+
+			"-recent values may be not the last ones (use GOMAXPROCS=1)")
+	}
+
+	if opts.rejects != "" {
+		rejects = openRejects(opts.rejects)
+		defer rejects.close()
+	}
+
+	var mergedResults map[string]Agg
+	switch {
+	case opts.window > 0:
+		f := openInput()
+		defer f.Close()
+		mergedResults = streamWindow(f)
+	case flag.NArg() > 0:
+		var readers []io.Reader
+		for _, path := range flag.Args() {
+			f, err := os.Open(path)
+			if err != nil {
+				panic(err)
+			}
+			defer f.Close()
+			readers = append(readers, f)
+		}
+		mergedResults = scanReaders(workers, readers...)
+	case isTarGz(opts.input):
+		f := openInput()
+		defer f.Close()
+		mergedResults = scanTarGz(f, workers)
+	case opts.stream:
+		f := openInput()
+		defer f.Close()
+		mergedResults = scanStream(f, workers)
+	case opts.checkpoint != "" || opts.resume != "":
+		mergedResults = scanWithCheckpoints(readData(opts.input), workers)
+	case opts.inputGlob != "":
+		paths, err := filepath.Glob(opts.inputGlob)
+		if err != nil {
+			log.Fatalf("bad -input-glob: %s", err)
+		}
+		if len(paths) == 0 {
+			log.Fatalf("no files match -input-glob %q", opts.inputGlob)
+		}
+		mergedResults = scanFiles(paths, workers)
+	case opts.useIndex != "":
+		mergedResults = scanIndexed(opts.input, workers)
+	default:
+		data := readData(opts.input)
+		if opts.buildIndex != "" {
+			size := len(data)
+			if stat, err := os.Stat(opts.input); err == nil {
+				size = int(stat.Size()) // data may have newline added at the end
+			}
+			saveIndex(opts.buildIndex, buildIndex(data), size)
+		}
+		if opts.sharedTable {
+			shared = newSharedTable()
+		}
+		results := mapScan(data, scan, workers)
+		if opts.monotonic != "" {
+			checkMonotonic(data)
+		}
+		if shared != nil {
+			mergedResults = shared.results()
 		} else {
-			agg.min = value
-			agg.max = value
-			agg.count++
-			agg.sum = value
+			mergedResults = reduce(results...)
 		}
-		m[key] = agg
 	}
 
-	return fixMap(m)
+	mergedResults = prepareResults(mergedResults)
+	if opts.presence {
+		printShards(diag)
+	}
+
+	if opts.stdoutJSONLines {
+		printJSONLines(mergedResults, sortedKeys(mergedResults), os.Stdout)
+	}
+
+	if opts.dumpMap != "" {
+		dumpMapToFile(mergedResults, opts.dumpMap)
+	}
+
+	if opts.assertOutput != "" {
+		if !assertOutput(mergedResults, opts.assertOutput, os.Stderr) {
+			pprof.StopCPUProfile()
+			os.Exit(1)
+		}
+		return mergedResults
+	}
+
+	writeResultsToFile(mergedResults)
+	if opts.recent > 0 {
+		writeRecent(mergedResults, recentPath)
+	}
+	return mergedResults
 }
 
-// mapScan splits data to chunks and run scanning in goroutines
+// chunkBoundaries splits data into at most n record aligned [from, to) ranges of roughly equal size.
+// Every record belongs to exactly one range, empty ranges are omitted
+// (so there are less than n ranges if data has less than n records)
+func chunkBoundaries(data []byte, n int) [][2]int {
+	size := len(data)
+	out := make([][2]int, 0, n)
+	from := 0
+	for k := 1; k <= n && from < size; k++ {
+		to := size
+		if k < n {
+			to = nextRecord(data, max(size*k/n, from)) // size/n*k would be 0 for n > size
+		}
+		if to > from {
+			out = append(out, [2]int{from, to})
+			from = to
+		}
+	}
+	return out
+}
+
+// nextRecord returns position of the first record which starts at or after i
+func nextRecord(data []byte, i int) int {
+	if i == 0 || i >= len(data) {
+		return min(i, len(data))
+	}
+	for data[i-1] != '\n' {
+		i++
+		if i == len(data) {
+			break
+		}
+	}
+	return i
+}
+
+// mapScan splits data to chunks and run scanning in goroutines.
+// Chunks are opts.chunkBytes long (or data is split evenly between workers if it's 0),
+// with -balance rows they have the same number of records instead of bytes,
+// workers take chunks from a queue until it's empty, so one big chunk per worker
+// doesn't hold everything and load is balanced
 func mapScan(
 	data []byte,
 	scanFunc func(data []byte, i int, end int) map[string]Agg,
 	workers int,
 ) []map[string]Agg {
 
-	n := len(data)
-	fmt.Printf("%d CPUs\n", workers)
-	shift := n / workers
+	boundaries := mapBoundaries(data, workers)
+	chunks := make(chan [2]int, len(boundaries))
+	for _, c := range boundaries {
+		chunks <- c
+	}
+	close(chunks)
+	if opts.pipeline {
+		return pipelineScan(data, chunks)
+	}
 
 	results := make([]map[string]Agg, workers)
+	stats := make([]workerStats, workers)
 	var wg sync.WaitGroup
 	for i := 0; i < workers; i++ {
 		i := i
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			from := i * shift
-			to := i*shift + shift
-			if i == workers-1 {
-				to = n
+			t0 := time.Now()
+			res := make(map[string]Agg, stationsHint())
+			for c := range chunks {
+				if stopped() {
+					break
+				}
+				if opts.affinityReport {
+					stats[i].sampleAffinity()
+				}
+				res = reduce(res, scanFunc(data, c[0], c[1]))
 			}
-			res := scanFunc(data, from, to)
+			stats[i].took = time.Since(t0)
 			results[i] = res
 		}()
 	}
 	wg.Wait()
 
+	if opts.dumpWorkerMaps != "" {
+		dumpWorkerMaps(opts.dumpWorkerMaps, results)
+	}
+	if opts.perWorkerStats {
+		for i, res := range results {
+			stats[i].rows = countRows(res)
+		}
+		printWorkerStats(stats, diag)
+	}
+	if opts.affinityReport {
+		printAffinity(stats, diag)
+	}
+
 	return results
 }
 
+// mapBoundaries returns chunks of data dispatched to mapScan workers
+func mapBoundaries(data []byte, workers int) [][2]int {
+	if opts.pipeline {
+		workers = opts.parseWorkers // chunks are taken by parsers
+	}
+	n := workers
+	if opts.chunkBytes > 0 {
+		n = (len(data) + opts.chunkBytes - 1) / opts.chunkBytes
+	}
+	if opts.balance == "rows" {
+		return rowBoundaries(data, n, workers)
+	}
+	return chunkBoundaries(data, n)
+}
+
+// workerStats is a diagnostic info about single mapScan worker
+type workerStats struct {
+	rows int
+	took time.Duration
+
+	// samples of CPU and OS thread worker ran on, for -affinity-report
+	cpus    map[int]int
+	threads map[int]int
+}
+
+// sampleAffinity records CPU and thread the calling worker currently runs on
+func (s *workerStats) sampleAffinity() {
+	if s.cpus == nil {
+		s.cpus, s.threads = make(map[int]int), make(map[int]int)
+	}
+	s.cpus[currentCPU()]++
+	s.threads[currentThread()]++
+}
+
+// countRows returns number of records aggregated into m
+func countRows(m map[string]Agg) int {
+	var rows int
+	for _, agg := range m {
+		rows += agg.count
+	}
+	return rows
+}
+
+func printWorkerStats(stats []workerStats, w io.Writer) {
+	var total int
+	for i, s := range stats {
+		fmt.Fprintf(w, "worker %d: %d rows, took %s\n", i, s.rows, s.took)
+		total += s.rows
+	}
+	fmt.Fprintf(w, "total: %d rows\n", total)
+}
+
+// printAffinity writes which CPUs and how many OS threads every worker was seen on,
+// sampled when worker takes a chunk. Worker hopping between CPUs or sharing one
+// with other workers explains imbalance that isn't in the data
+func printAffinity(stats []workerStats, w io.Writer) {
+	for i, s := range stats {
+		cpus := make([]int, 0, len(s.cpus))
+		for cpu := range s.cpus {
+			cpus = append(cpus, cpu)
+		}
+		sort.Ints(cpus)
+		fmt.Fprintf(w, "worker %d: cpus", i)
+		for _, cpu := range cpus {
+			if cpu < 0 {
+				fmt.Fprintf(w, " unknown x%d", s.cpus[cpu])
+			} else {
+				fmt.Fprintf(w, " %d x%d", cpu, s.cpus[cpu])
+			}
+		}
+		fmt.Fprintf(w, ", %d threads\n", len(s.threads))
+	}
+}
+
+// scanFiles processes files one by one (each with all workers) and merges their results,
+// so only one file has to fit in memory
+func scanFiles(paths []string, workers int) map[string]Agg {
+	merged := make(map[string]Agg)
+	for _, path := range paths {
+		if stopped() {
+			break
+		}
+		results := mapScan(readData(path), scan, workers)
+		if opts.presence {
+			markShard(addShard(path), results...)
+		}
+		merged = reduce(append([]map[string]Agg{merged}, results...)...)
+	}
+	return merged
+}
+
+// globalKey is a station name of aggregate over all stations
+const globalKey = "__ALL__"
+
+// globalAgg merges aggregates of all stations into one,
+// so its mean is weighted by stations counts
+func globalAgg(data map[string]Agg) Agg {
+	// starts empty rather than from a copy of some station, which would share its counts map
+	out := Agg{min: math.Inf(1), max: math.Inf(-1)}
+	for _, v := range data {
+		out.Merge(v)
+	}
+	return out
+}
+
 // reduce merges chunks results together
 func reduce(data ...map[string]Agg) map[string]Agg {
 	out := data[0]
@@ -169,89 +665,97 @@ func reduce(data ...map[string]Agg) map[string]Agg {
 				continue
 			}
 
-			outValue.sum += value.sum
-			outValue.min = min(outValue.min, value.min)
-			outValue.max = max(outValue.max, value.max)
-			outValue.count += value.count
+			outValue.Merge(value)
 			out[key] = outValue
 		}
 	}
 	return out
 }
 
-// fastFloat parses slice of bytes into float64 without conversion to string
-func fastFloat(b []byte) float64 {
-	var sign float64 = 1
-	var result float64
-	var divisor float64 = 1
-	decimalPointPassed := false
-
-	var i int
-	if b[i] == '-' {
-		sign = -1
-		i++
-	}
+// round rounds value to one decimal, half-way cases go towards positive infinity
+// (same as reference implementation). Accumulation error can put a mean that is
+// exactly x.x5 slightly below the boundary depending on how data was chunked,
+// so value is nudged by opts.roundingEpsilon before rounding.
+func round(value float64) float64 {
+	return math.Floor((value+opts.roundingEpsilon)*10+0.5) / 10
+}
 
-	var char byte
-	for ; i < len(b); i++ {
-		char = b[i]
-		if char == '.' {
-			decimalPointPassed = true
-			continue
-		}
+// ---
+// NOT SIGNIFICANT FUNCTIONS BELOW (helpers for read and simple conversions)
+// ---
 
-		if char < '0' || char > '9' {
-			panic(errors.New("expected [0,9]"))
+// decodeKeys transcodes station names to UTF-8. It's done once per station after aggregation,
+// not per record: scan works on raw bytes, only names in output have to be valid UTF-8.
+// Stations whose names decode to the same string are merged
+func decodeKeys(m map[string]Agg, decoder *encoding.Decoder) map[string]Agg {
+	out := make(map[string]Agg, len(m))
+	for key, agg := range m {
+		decoded, err := decoder.String(key)
+		if err != nil {
+			panic(fmt.Errorf("can't decode station name %q: %w", key, err))
 		}
-		digit := float64(char - '0')
-
-		if decimalPointPassed {
-			divisor *= 10
-			result += digit / divisor
-		} else {
-			result = result*10 + digit
+		if prev, ok := out[decoded]; ok {
+			prev.Merge(agg)
+			agg = prev
 		}
+		out[decoded] = agg
 	}
-
-	return result * sign
-
+	return out
 }
 
-// ---
-// NOT SIGNIFICANT FUNCTIONS BELOW (helpers for read and simple conversions)
-// ---
+// hashKey returns stable pseudonym of station name for -hash-keys
+func hashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:8])
+}
 
-// fixMap converts map from [keySize]int keyed into `string` keyed
-func fixMap(m1 map[[keySize]byte]Agg) map[string]Agg {
-	out := make(map[string]Agg, len(m1))
-L:
-	for key := range m1 {
-		for i, b := range key {
-			if b == 0x0 {
-				out[string(key[:i])] = m1[key]
-				continue L
-			}
+// hashKeys replaces station names with hashKey of them, aggregates are kept as they are
+func hashKeys(m map[string]Agg) map[string]Agg {
+	out := make(map[string]Agg, len(m))
+	for key, agg := range m {
+		hashed := hashKey(key)
+		if _, ok := out[hashed]; ok {
+			// 64 bits of hash, practically impossible, but stations must not be merged silently
+			panic(fmt.Errorf("hash %s of station %q collides with another station", hashed, key))
 		}
-		out[string(key[:])] = m1[key]
+		out[hashed] = agg
 	}
 	return out
 }
 
-// readData reads data from ./data/measurements.txt file
+// derefMap converts map with pointer values (cheap to update in place) into map with plain values
+func derefMap(m map[string]*Agg) map[string]Agg {
+	out := make(map[string]Agg, len(m))
+	for key, agg := range m {
+		out[key] = *agg
+	}
+	return out
+}
+
+// readData reads data from file at path ("-" for stdin)
 // Data can be generated via tools in
 // https://github.com/gunnarmorling/1brc repository
-func readData() []byte {
-	f, err := os.Open("./data/measurements.txt")
+func readData(path string) []byte {
+	if path == "-" {
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			panic(err)
+		}
+		return terminateLastLine(data)
+	}
+
+	f, err := os.Open(path)
 	if err != nil {
 		panic(err)
 	}
+	defer f.Close()
 
 	stat, err := f.Stat()
 	if err != nil {
 		panic(err)
 	}
 	size := stat.Size()
-	data := make([]byte, size)
+	data := make([]byte, size, size+1) // room for missing trailing newline
 	n, err := io.ReadFull(f, data)
 	if err != nil {
 		panic(err)
@@ -259,38 +763,77 @@ func readData() []byte {
 	if n != int(size) {
 		panic("n != size")
 	}
+	return terminateLastLine(data)
+}
+
+// terminateLastLine appends newline if the last record doesn't have it,
+// so parsing can always expect '\n' at the end of record
+func terminateLastLine(data []byte) []byte {
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		data = append(data, '\n')
+	}
 	return data
 }
 
-func writeResultsToFile(results map[string]Agg) {
-	resF, err := os.Create("result.txt")
+// readKeysFile reads station per line, empty lines are ignored
+func readKeysFile(path string) []string {
+	content, err := os.ReadFile(path)
 	if err != nil {
 		panic(err)
 	}
-	defer resF.Close()
-	printResults(results, resF)
+	var keys []string
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		if line != "" {
+			keys = append(keys, line)
+		}
+	}
+	return keys
 }
 
-func printResults(data map[string]Agg, w io.Writer) {
-	var keys = make([]string, 0, len(data))
-	for key, _ := range data {
-		keys = append(keys, key)
+// openInput opens opts.input for streaming read, "-" means stdin
+func openInput() io.ReadCloser {
+	if opts.input == "-" {
+		return io.NopCloser(os.Stdin)
 	}
-	sort.Strings(keys)
-
-	w.Write([]byte{'{'})
-
-	var res string
-	for _, key := range keys[:len(keys)-1] {
-		v := data[key]
-		res = fmt.Sprintf("%s=%.1f/%.1f/%.1f, ", key, v.min, v.sum/float64(v.count), v.max)
-		w.Write([]byte(res))
+	f, err := os.Open(opts.input)
+	if err != nil {
+		panic(err)
 	}
+	return f
+}
 
-	key := keys[len(keys)-1]
-	v := data[key]
-	res = fmt.Sprintf("%s=%.1f/%.1f/%.1f", key, v.min, v.sum/float64(v.count), v.max)
-	w.Write([]byte(res))
-
-	w.Write([]byte{'}'})
+// preview parses first n records of input and prints them to w.
+// Only the needed lines are read, so it is instant even for huge files
+func preview(n int, w io.Writer) {
+	f := openInput()
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	var scratch []byte
+	for ; n > 0; n-- {
+		// unlike ReadSlice, lines may be longer than reader buffer
+		line, err := r.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			panic(err)
+		}
+		if len(line) == 0 {
+			return
+		}
+		if line[len(line)-1] != '\n' {
+			line = append(line, '\n') // last line without newline
+		}
+		var (
+			i  int
+			ts time.Time
+		)
+		if opts.timeBucket > 0 {
+			ts, i = parseTimestamp(line, i)
+		}
+		key, value, _ := parseRecord(line, i, &scratch)
+		if opts.timeBucket > 0 {
+			key = bucketKey(nil, ts, key)
+		}
+		fmt.Fprintf(w, "%s -> %.1f\n", key, value)
+	}
 }
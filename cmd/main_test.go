@@ -0,0 +1,1154 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// TestMain runs main instead of tests in processes started by runMain
+func TestMain(m *testing.M) {
+	if os.Getenv("BRC_TEST_MAIN") == "1" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// runMain runs program with args in dir, returns its combined output and exit code
+func runMain(t *testing.T, dir string, args ...string) (string, int) {
+	t.Helper()
+	// not os.Args[0], which is replaced by setFlags
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(exe, args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "BRC_TEST_MAIN=1")
+	out, err := cmd.CombinedOutput()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return string(out), exitErr.ExitCode()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out), 0
+}
+
+// setFlags parses command line args into opts like main does and restores previous opts after test
+func setFlags(t testing.TB, args ...string) {
+	t.Helper()
+	savedOpts, savedArgs, savedFlags, savedDiag := opts, os.Args, flag.CommandLine, diag
+	t.Cleanup(func() {
+		opts, os.Args, flag.CommandLine, diag = savedOpts, savedArgs, savedFlags, savedDiag
+	})
+	opts = options{}
+	flag.CommandLine = flag.NewFlagSet("brc", flag.ContinueOnError)
+	os.Args = append([]string{"brc"}, args...)
+	parseFlags()
+}
+
+// writeFile writes content to file name in temporary directory of test and returns its path
+func writeFile(t testing.TB, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// captureDiag redirects diag to returned buffer until the end of test
+func captureDiag(t testing.TB) *bytes.Buffer {
+	var b bytes.Buffer
+	saved := diag
+	diag = &b
+	t.Cleanup(func() { diag = saved })
+	return &b
+}
+
+// genMeasurements returns rows of 1BRC-like records of stations named Station0, Station1, ...
+// with values in [-99.9, 99.9]. Data is the same for the same arguments
+func genMeasurements(rows, stations int) []byte {
+	r := rand.New(rand.NewSource(1))
+	var b bytes.Buffer
+	b.Grow(rows * 16)
+	for i := 0; i < rows; i++ {
+		fmt.Fprintf(&b, "Station%d;%.1f\n", r.Intn(stations), float64(r.Intn(1999)-999)/10)
+	}
+	return b.Bytes()
+}
+
+// aggregate processes data like run does for single input file
+func aggregate(data string, workers int) map[string]Agg {
+	results := reduce(mapScan(terminateLastLine([]byte(data)), scan, workers)...)
+	return prepareResults(results)
+}
+
+// formatResults returns results written in format
+func formatResults(results map[string]Agg, format string) string {
+	var b bytes.Buffer
+	formats[format](results, sortedKeys(results), &b)
+	return b.String()
+}
+
+// panicMessage returns message of panic of f, it fails test if f doesn't panic
+func panicMessage(t *testing.T, f func()) (msg string) {
+	t.Helper()
+	defer func() {
+		r := recover()
+		if r == nil {
+			t.Fatal("expected panic")
+		}
+		msg = fmt.Sprint(r)
+	}()
+	f()
+	return ""
+}
+
+func TestRoundingEpsilon(t *testing.T) {
+	// mean is exactly -24.15, but sum depends on order of additions: -24.15 or -24.150000000000002
+	const data = "A;-22.5\nA;14.3\nA;-40.9\nA;-47.5\n"
+	for _, tt := range []struct {
+		epsilon string
+		whole   string // single chunk, values are added one by one
+		chunked string // two chunks of two records, their sums are added
+	}{
+		{"0", "{A=-47.5/-24.1/14.3}", "{A=-47.5/-24.2/14.3}"},
+		{"1e-9", "{A=-47.5/-24.1/14.3}", "{A=-47.5/-24.1/14.3}"},
+	} {
+		t.Run(tt.epsilon, func(t *testing.T) {
+			setFlags(t, "-rounding-epsilon", tt.epsilon, "-chunk-bytes", "0")
+			if got := formatResults(aggregate(data, 1), "brc"); got != tt.whole {
+				t.Errorf("whole: got %s, want %s", got, tt.whole)
+			}
+			setFlags(t, "-rounding-epsilon", tt.epsilon, "-chunk-bytes", "16")
+			if got := formatResults(aggregate(data, 1), "brc"); got != tt.chunked {
+				t.Errorf("chunked: got %s, want %s", got, tt.chunked)
+			}
+		})
+	}
+}
+
+func TestPreview(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		input string
+		n     string
+		flags []string
+		want  string
+	}{
+		{"first lines", "A;1.0\nB;2.0\nC;3.0\n", "2", nil, "A -> 1.0\nB -> 2.0\n"},
+		{"fewer lines than n", "A;1.0\nB;-2.5\n", "5", nil, "A -> 1.0\nB -> -2.5\n"},
+		{"last line without newline", "A;1.0\nB;2.0", "5", nil, "A -> 1.0\nB -> 2.0\n"},
+		{"empty input", "", "3", nil, ""},
+		{"delimiters and quotes", "\"A\";\"1.5\"\nB,2.0\n", "2", []string{"-delimiters", ";,", "-quoted-fields"},
+			"A -> 1.5\nB -> 2.0\n"},
+		{"time bucket", "2024-01-01T10:15:00Z;A;1.0\n", "1", []string{"-time-bucket", "1h"},
+			"2024-01-01T10:00:00Z|A -> 1.0\n"},
+		{"line longer than reader buffer", strings.Repeat("x", 10000) + ";1.0\n", "1", nil,
+			strings.Repeat("x", 10000) + " -> 1.0\n"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeFile(t, "input.txt", tt.input)
+			setFlags(t, append([]string{"-input", path, "-preview", tt.n}, tt.flags...)...)
+			var b bytes.Buffer
+			preview(opts.preview, &b)
+			if b.String() != tt.want {
+				t.Errorf("got %q, want %q", b.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestQuotedFields(t *testing.T) {
+	setFlags(t, "-quoted-fields")
+	for _, tt := range []struct {
+		record string
+		key    string
+		value  float64
+	}{
+		{"\"Paris\";\"12.3\"\n", "Paris", 12.3},
+		{"Paris;\"-0.5\"\n", "Paris", -0.5},
+		{"\"Paris\";7.0\n", "Paris", 7},
+		{"Paris;12.3\n", "Paris", 12.3},
+		{"\"St. \"John\"\";1.0\n", "St. \"John\"", 1}, // only surrounding quotes are stripped
+	} {
+		key, value, next := parseRecord([]byte(tt.record), 0, nil)
+		if string(key) != tt.key || value != tt.value || next != len(tt.record) {
+			t.Errorf("%q: got %q %v %d, want %q %v %d", tt.record, key, value, next, tt.key, tt.value, len(tt.record))
+		}
+	}
+
+	got := formatResults(aggregate("\"A\";\"1.0\"\n\"A\";\"2.0\"\nB;\"3.0\"\n", 1), "brc")
+	if want := "{A=1.0/1.5/2.0, B=3.0/3.0/3.0}"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestPerWorkerStats(t *testing.T) {
+	setFlags(t, "-per-worker-stats", "-chunk-bytes", "1024")
+	out := captureDiag(t)
+
+	const rows = 10000
+	mapScan(genMeasurements(rows, 100), scan, 4)
+
+	var sum, total, workers int
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		var worker, n int
+		if _, err := fmt.Sscanf(line, "worker %d: %d rows", &worker, &n); err == nil {
+			sum += n
+			workers++
+		} else if _, err := fmt.Sscanf(line, "total: %d rows", &total); err != nil {
+			t.Fatalf("unexpected line %q", line)
+		}
+	}
+	if workers != 4 || sum != rows || total != rows {
+		t.Errorf("got %d workers with %d rows in sum, total %d, want 4 workers and %d rows\n%s",
+			workers, sum, total, rows, out.String())
+	}
+}
+
+func TestStrictRange(t *testing.T) {
+	data := []byte("A;12.0\nA;150.0\nB;-99.9\n")
+	t.Run("error", func(t *testing.T) {
+		setFlags(t, "-strict-range")
+		msg := panicMessage(t, func() { scan(data, 0, len(data)) })
+		if want := `value 150.0 of "A" is out of range [-99.9, 99.9]`; msg != want {
+			t.Errorf("got %q, want %q", msg, want)
+		}
+	})
+	t.Run("skip", func(t *testing.T) {
+		setFlags(t, "-strict-range", "-skip-bad")
+		got := formatResults(aggregate(string(data), 1), "brc")
+		if want := "{A=12.0/12.0/12.0, B=-99.9/-99.9/-99.9}"; got != want {
+			t.Errorf("got %s, want %s", got, want)
+		}
+	})
+	t.Run("custom range", func(t *testing.T) {
+		setFlags(t, "-strict-range", "-skip-bad", "-range-min", "-50", "-range-max", "200")
+		got := formatResults(aggregate(string(data), 1), "brc")
+		if want := "{A=12.0/81.0/150.0}"; got != want {
+			t.Errorf("got %s, want %s", got, want)
+		}
+	})
+}
+
+func TestNoClobber(t *testing.T) {
+	results := map[string]Agg{"A": newAgg(1)}
+	t.Run("existing file", func(t *testing.T) {
+		path := writeFile(t, "result.txt", "precious")
+		setFlags(t, "-no-clobber", "-output", path)
+		panicMessage(t, func() { writeResultsToFile(results) })
+		if content, _ := os.ReadFile(path); string(content) != "precious" {
+			t.Errorf("file is overwritten with %q", content)
+		}
+		if entries, _ := os.ReadDir(filepath.Dir(path)); len(entries) != 1 {
+			t.Errorf("temporary file is left: %v", entries)
+		}
+	})
+	t.Run("new file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "result.txt")
+		setFlags(t, "-no-clobber", "-output", path)
+		writeResultsToFile(results)
+		if content, _ := os.ReadFile(path); string(content) != "{A=1.0/1.0/1.0}" {
+			t.Errorf("got %q", content)
+		}
+	})
+	// other files of the run are checked before input is processed
+	for _, tt := range []struct {
+		file string
+		args []string
+	}{
+		{"recent.txt", []string{"-recent", "2"}},
+		{"map.txt", []string{"-dump-map", "map.txt"}},
+		{"rejects.txt", []string{"-rejects", "rejects.txt", "-skip-bad"}},
+		{"result.snapshot.txt.1", []string{"-stream", "-snapshot-every", "1"}},
+	} {
+		t.Run(tt.file, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, content := range map[string]string{"in.txt": "A;1.0\n", tt.file: "precious"} {
+				if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			out, code := runMain(t, dir, append([]string{"-input", "in.txt", "-no-clobber"}, tt.args...)...)
+			if code == 0 || !strings.Contains(out, tt.file+" already exists") {
+				t.Errorf("exit %d: %s", code, out)
+			}
+			if content, _ := os.ReadFile(filepath.Join(dir, tt.file)); string(content) != "precious" {
+				t.Errorf("file is overwritten with %q", content)
+			}
+		})
+	}
+	t.Run("worker maps", func(t *testing.T) {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "map-000-worker-0.txt")
+		if err := os.WriteFile(path, []byte("precious"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		setFlags(t, "-no-clobber")
+		saved := workerMapsDumps
+		t.Cleanup(func() { workerMapsDumps = saved })
+		workerMapsDumps = 0
+		panicMessage(t, func() { dumpWorkerMaps(dir, []map[string]Agg{results}) })
+		if content, _ := os.ReadFile(path); string(content) != "precious" {
+			t.Errorf("file is overwritten with %q", content)
+		}
+	})
+}
+
+func TestDelimiters(t *testing.T) {
+	setFlags(t, "-delimiters", ";,")
+	got := formatResults(aggregate("A;1.0\nA,3.0\nB,2.0\nB;4.0\n", 1), "brc")
+	if want := "{A=1.0/2.0/3.0, B=2.0/3.0/4.0}"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	// name containing one of delimiters is split on it, the rest is taken as value
+	data := []byte("Washington, D.C.;5.0\n")
+	if msg := panicMessage(t, func() { scan(data, 0, len(data)) }); msg != "expected [0,9]" {
+		t.Errorf("got panic %q", msg)
+	}
+}
+
+func TestHelpExamples(t *testing.T) {
+	for _, line := range []string{
+		"brc -input-glob 'data/*.txt'",               // custom input
+		"brc -output result.json -output result.csv", // JSON output
+		"brc -iqr -trimmed-mean 0.1 -format table",   // percentiles
+		"brc -parallel-write 4 -output result.json",  // sharding
+	} {
+		if !strings.Contains(examples, "\n  "+line+"\n") {
+			t.Errorf("no example %q", line)
+		}
+	}
+	for _, line := range strings.Split(strings.TrimSpace(examples), "\n")[1:] {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "# ") && !strings.Contains(line, "brc") {
+			t.Errorf("example %q doesn't run brc", line)
+		}
+	}
+}
+
+func TestGeomean(t *testing.T) {
+	setFlags(t, "-geomean", "-format", "csv")
+	// sqrt(2*8) and cbrt(1*10*100)
+	got := formatResults(aggregate("A;2.0\nB;1.0\nA;8.0\nB;10.0\nB;100.0\n", 2), "csv")
+	want := "station,min,mean,max,geomean\nA,2.0,5.0,8.0,4.0\nB,1.0,37.0,100.0,10.0\n"
+	if got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+
+	data := []byte("A;2.0\nA;-1.0\nA;0.0\nA;8.0\n")
+	msg := panicMessage(t, func() { scan(data, 0, len(data)) })
+	if want := `value -1.0 of "A" is not positive, geometric mean is undefined`; msg != want {
+		t.Errorf("got panic %q, want %q", msg, want)
+	}
+
+	setFlags(t, "-geomean", "-skip-bad")
+	got = formatResults(aggregate(string(data), 1), "csv")
+	if want := "station,min,mean,max,geomean\nA,2.0,5.0,8.0,4.0\n"; got != want {
+		t.Errorf("skip: got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestChunkBoundaries(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		data string
+		n    int
+		want [][2]int
+	}{
+		{"empty", "", 4, [][2]int{}},
+		{"single chunk", "a;1\nb;2\n", 1, [][2]int{{0, 8}}},
+		{"even split", "a;1\nb;2\n", 2, [][2]int{{0, 4}, {4, 8}}},
+		{"split inside record", "aa;1\nb;2\nc;3\n", 2, [][2]int{{0, 9}, {9, 13}}},
+		{"no newlines", "a;1", 3, [][2]int{{0, 3}}},
+		{"trailing partial record", "a;1\nb;2\nc;3", 3, [][2]int{{0, 4}, {4, 8}, {8, 11}}},
+		{"n larger than lines", "a;1\nb;2\n", 10, [][2]int{{0, 4}, {4, 8}}},
+		{"split inside long record", "a;1\n" + strings.Repeat("x", 20) + ";2\nb;3\n", 4,
+			[][2]int{{0, 27}, {27, 31}}}, // 2 splits fall into the same record
+		{"only newlines", "\n\n\n", 2, [][2]int{{0, 1}, {1, 3}}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got := chunkBoundaries([]byte(tt.data), tt.n)
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestChunkBoundariesExhaustive checks every split of all inputs made of short records
+func TestChunkBoundariesExhaustive(t *testing.T) {
+	// records of length 1..4 (with newline), last one with or without newline
+	var inputs []string
+	var gen func(prefix string, records int)
+	gen = func(prefix string, records int) {
+		inputs = append(inputs, prefix, strings.TrimSuffix(prefix, "\n"))
+		if records == 0 {
+			return
+		}
+		for size := 1; size <= 4; size++ {
+			gen(prefix+strings.Repeat("x", size-1)+"\n", records-1)
+		}
+	}
+	gen("", 5)
+
+	for _, input := range inputs {
+		data := []byte(input)
+		for n := 1; n <= len(data)+2; n++ {
+			chunks := chunkBoundaries(data, n)
+			if len(chunks) > n {
+				t.Fatalf("%q, n=%d: %d chunks", input, n, len(chunks))
+			}
+			from := 0
+			for _, c := range chunks {
+				if c[0] != from || c[1] <= c[0] {
+					t.Fatalf("%q, n=%d: chunks %v have gap, overlap or empty chunk", input, n, chunks)
+				}
+				if c[0] > 0 && data[c[0]-1] != '\n' {
+					t.Fatalf("%q, n=%d: chunk %v starts inside record", input, n, c)
+				}
+				from = c[1]
+			}
+			if from != len(data) {
+				t.Fatalf("%q, n=%d: chunks %v don't cover data", input, n, chunks)
+			}
+		}
+	}
+}
+
+func TestConfidenceInterval(t *testing.T) {
+	setFlags(t, "-confidence", "0.95")
+	results := aggregate("A;10.0\nA;12.0\nA;14.0\nA;16.0\nA;18.0\nB;5.0\n", 2)
+
+	// mean 14, sample variance 10, standard error sqrt(10/5), z of 95% is 1.959964
+	low, high := results["A"].confidenceInterval(0.95)
+	margin := 1.959964 * math.Sqrt(2)
+	if math.Abs(low-(14-margin)) > 1e-6 || math.Abs(high-(14+margin)) > 1e-6 {
+		t.Errorf("got [%v, %v], want [%v, %v]", low, high, 14-margin, 14+margin)
+	}
+
+	// interval of single reading is undefined
+	want := `[
+  {"station": "A", "min": 10.0, "mean": 14.0, "max": 18.0, "ci_low": 11.2, "ci_high": 16.8},
+  {"station": "B", "min": 5.0, "mean": 5.0, "max": 5.0, "ci_low": null, "ci_high": null}
+]
+`
+	if got := formatResults(results, "json"); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestGlobal(t *testing.T) {
+	setFlags(t, "-global")
+	results := aggregate("A;1.0\nA;2.0\nA;3.0\nB;10.0\nC;-4.0\n", 2)
+	global := results[globalKey]
+	// weighted by counts: (1+2+3+10-4)/5, while mean of station means would be (2+10-4)/3
+	if global.count != 5 || global.mean() != 2.4 || global.min != -4 || global.max != 10 {
+		t.Errorf("got count %d mean %v min %v max %v", global.count, global.mean(), global.min, global.max)
+	}
+	got := formatResults(results, "brc")
+	if want := "{A=1.0/2.0/3.0, B=10.0/10.0/10.0, C=-4.0/-4.0/-4.0, __ALL__=-4.0/2.4/10.0}"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestInputEncoding(t *testing.T) {
+	setFlags(t, "-input-encoding", "latin1")
+	got := formatResults(aggregate("Z\xfcrich;1.0\nS\xe3o Paulo;2.0\nZ\xfcrich;3.0\n", 1), "brc")
+	if want := "{São Paulo=2.0/2.0/2.0, Zürich=1.0/2.0/3.0}"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if !utf8.ValidString(got) {
+		t.Errorf("output is not valid UTF-8")
+	}
+
+	setFlags(t, "-input-encoding", "windows-1251")
+	got = formatResults(aggregate("\xcc\xee\xf1\xea\xe2\xe0;-5.0\n", 1), "brc")
+	if want := "{Москва=-5.0/-5.0/-5.0}"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestMissingValue(t *testing.T) {
+	const data = "Paris;\nParis;4.0\nOslo;\n"
+	for _, tt := range []struct {
+		policy string
+		want   string // output, or panic message
+	}{
+		{"skip", "{Paris=4.0/4.0/4.0}"},
+		{"zero", "{Oslo=0.0/0.0/0.0, Paris=0.0/2.0/4.0}"},
+		{"error", `missing value of "Paris"`},
+	} {
+		t.Run(tt.policy, func(t *testing.T) {
+			setFlags(t, "-missing-value", tt.policy)
+			if tt.policy == "error" {
+				got := panicMessage(t, func() { scan([]byte(data), 0, len(data)) })
+				if got != tt.want {
+					t.Errorf("got panic %q, want %q", got, tt.want)
+				}
+				return
+			}
+			if got := formatResults(aggregate(data, 1), "brc"); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHarmonic(t *testing.T) {
+	setFlags(t, "-harmonic")
+	// 2/(1/40+1/60) and 3/(1/1+1/2+1/4)
+	results := aggregate("A;40.0\nB;1.0\nA;60.0\nB;2.0\nB;4.0\n", 2)
+	if got := float64(results["B"].count) / results["B"].sumRecip; math.Abs(got-12.0/7) > 1e-12 {
+		t.Errorf("got harmonic mean %v of B, want %v", got, 12.0/7)
+	}
+	want := "station,min,mean,max,harmonic\nA,40.0,50.0,60.0,48.0\nB,1.0,2.3,4.0,1.7\n"
+	if got := formatResults(results, "csv"); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+
+	data := []byte("A;40.0\nA;0.0\nA;60.0\n")
+	msg := panicMessage(t, func() { scan(data, 0, len(data)) })
+	if want := `value of "A" is zero, harmonic mean is undefined`; msg != want {
+		t.Errorf("got panic %q, want %q", msg, want)
+	}
+	setFlags(t, "-harmonic", "-skip-bad")
+	if got := formatResults(aggregate(string(data), 1), "csv"); got != "station,min,mean,max,harmonic\nA,40.0,50.0,60.0,48.0\n" {
+		t.Errorf("skip: got\n%s", got)
+	}
+}
+
+func TestInputGlob(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"a.txt":     "Paris;1.0\nOslo;-2.0\n",
+		"b.txt":     "Paris;3.0\n",
+		"c.txt":     "Oslo;4.0", // no newline at the end
+		"d.csv":     "Paris;100.0\n",
+		"notes.md":  "not measurements",
+		"old/e.txt": "Paris;-100.0\n", // glob doesn't go into subdirectories
+	} {
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 0o755)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	out, code := runMain(t, dir, "-input-glob", "*.txt", "-output", "result.txt")
+	if code != 0 {
+		t.Fatalf("exit %d: %s", code, out)
+	}
+	got, _ := os.ReadFile(filepath.Join(dir, "result.txt"))
+	if want := "{Oslo=-2.0/1.0/4.0, Paris=1.0/2.0/3.0}"; string(got) != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	out, code = runMain(t, dir, "-input-glob", "*.json")
+	if code != 1 || !strings.Contains(out, `no files match -input-glob "*.json"`) {
+		t.Errorf("no matches: exit %d: %s", code, out)
+	}
+}
+
+func TestRecordInlineSep(t *testing.T) {
+	setFlags(t, "-delimiters", ":", "-record-inline-sep", ",")
+	for _, tt := range []struct {
+		name, data string
+	}{
+		{"one line", "A:1.0,B:2.0,A:3.0,C:-1.5,B:4.0,A:2.0"},
+		{"several lines", "A:1.0,B:2.0\nA:3.0\nC:-1.5,B:4.0,A:2.0\n"},
+		{"record per line", "A:1.0\nB:2.0\nA:3.0\nC:-1.5\nB:4.0\nA:2.0\n"},
+	} {
+		for _, workers := range []int{1, 3} {
+			got := formatResults(aggregate(tt.data, workers), "brc")
+			if want := "{A=1.0/2.0/3.0, B=2.0/3.0/4.0, C=-1.5/-1.5/-1.5}"; got != want {
+				t.Errorf("%s, %d workers: got %s, want %s", tt.name, workers, got, want)
+			}
+		}
+	}
+}
+
+func TestClamp(t *testing.T) {
+	const data = "A;-30.0\nA;5.0\nA;40.0\nB;50.0\nC;-50.0\n"
+	for _, tt := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{}, "{A=-30.0/5.0/40.0, B=50.0/50.0/50.0, C=-50.0/-50.0/-50.0}"},
+		// -30 is pulled to -10 and 40 to 20: mean of -10, 5, 20
+		{[]string{"-min-value", "-10", "-max-value", "20"}, "{A=-10.0/5.0/20.0, B=20.0/20.0/20.0, C=-10.0/-10.0/-10.0}"},
+		{[]string{"-min-value", "-10"}, "{A=-10.0/11.7/40.0, B=50.0/50.0/50.0, C=-10.0/-10.0/-10.0}"},
+		{[]string{"-max-value", "0"}, "{A=-30.0/-10.0/0.0, B=0.0/0.0/0.0, C=-50.0/-50.0/-50.0}"},
+		{[]string{"-min-value", "7", "-max-value", "7"}, "{A=7.0/7.0/7.0, B=7.0/7.0/7.0, C=7.0/7.0/7.0}"},
+	} {
+		setFlags(t, tt.args...)
+		if got := formatResults(aggregate(data, 2), "brc"); got != tt.want {
+			t.Errorf("%v: got %s, want %s", tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestEmptyKey(t *testing.T) {
+	data := []byte("A;1.0\n;12.3\nA;3.0\n;-2.3\n")
+	for _, tt := range []struct {
+		policy string
+		want   string
+	}{
+		{"keep", "{=-2.3/5.0/12.3, A=1.0/2.0/3.0}"},
+		{"skip", "{A=1.0/2.0/3.0}"},
+	} {
+		t.Run(tt.policy, func(t *testing.T) {
+			setFlags(t, "-empty-key", tt.policy)
+			if got := formatResults(aggregate(string(data), 2), "brc"); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+	t.Run("error", func(t *testing.T) {
+		setFlags(t, "-empty-key", "error")
+		msg := panicMessage(t, func() { scan(data, 0, len(data)) })
+		if want := "record with empty station name (value 12.3)"; msg != want {
+			t.Errorf("got %q, want %q", msg, want)
+		}
+	})
+}
+
+func TestTrimmedMean(t *testing.T) {
+	for _, tt := range []struct {
+		values   string
+		fraction float64
+		want     float64
+	}{
+		// 10 values, 0.1 drops one from each end: mean of 2..9
+		{"1 2 3 4 5 6 7 8 9 100", 0.1, 5.5},
+		{"1 2 3 4 5 6 7 8 9 100", 0.2, 5.5},
+		// int(9*0.1) = 0, nothing is trimmed
+		{"1 2 3 4 5 6 7 8 100", 0.1, 136.0 / 9},
+		// duplicates are trimmed partly: sorted 1 1 1 5 5 9 9 9, two dropped from each end: 1 5 5 9
+		{"9 1 5 9 1 5 9 1", 0.25, 5},
+		{"-50 -1 0 1 50", 0.2, 0},
+		{"7", 0.4, 7},
+	} {
+		setFlags(t, "-trimmed-mean", fmt.Sprint(tt.fraction))
+		var data strings.Builder
+		for _, v := range strings.Fields(tt.values) {
+			data.WriteString("A;" + v + ".0\n")
+		}
+		if got := aggregate(data.String(), 2)["A"].trimmedMean(tt.fraction); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("%s, %v: got %v, want %v", tt.values, tt.fraction, got, tt.want)
+		}
+	}
+
+	// the same computed by sorting all values
+	setFlags(t, "-trimmed-mean", "0.1")
+	data := genMeasurements(5000, 3)
+	for key, agg := range aggregate(string(data), 3) {
+		var values []float64
+		for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+			name, value, _ := strings.Cut(line, ";")
+			if name == key {
+				v, _ := strconv.ParseFloat(value, 64)
+				values = append(values, v)
+			}
+		}
+		sort.Float64s(values)
+		trim := len(values) / 10
+		sum := 0.0
+		for _, v := range values[trim : len(values)-trim] {
+			sum += v
+		}
+		want := sum / float64(len(values)-2*trim)
+		if got := agg.trimmedMean(0.1); math.Abs(got-want) > 1e-9 {
+			t.Errorf("%s: got %v, want %v", key, got, want)
+		}
+	}
+}
+
+func TestExactFloat(t *testing.T) {
+	var inputs []string
+	for v := -999; v <= 999; v++ {
+		inputs = append(inputs, strconv.FormatFloat(float64(v)/10, 'f', 1, 64))
+	}
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 10000; i++ {
+		// up to 15 digits with up to 10 decimals, exact path
+		inputs = append(inputs, strconv.FormatFloat(r.NormFloat64()*math.Pow10(r.Intn(6)), 'f', r.Intn(11), 64))
+	}
+	inputs = append(inputs,
+		"-0.0", "0", "007.50", "12.", "123456789012345", // exact path edge cases
+		"1234567890123456.7", "0.1234567890123456789", "-99999999999999999", // strconv fallback
+	)
+
+	for _, s := range inputs {
+		want, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// bit for bit, including sign of zero
+		if got := exactFloat([]byte(s)); math.Float64bits(got) != math.Float64bits(want) {
+			t.Errorf("%s: got %v, want %v", s, got, want)
+		}
+	}
+}
+
+func TestCollapseWhitespace(t *testing.T) {
+	for _, tt := range []struct{ in, want string }{
+		{"", ""},
+		{"New York", "New York"},
+		{"New   York", "New York"},
+		{"New\tYork", "New York"},
+		{"New \t\r York", "New York"},
+		{" New York  ", " New York "},
+		{"\tOslo", " Oslo"},
+		{"a b c  d", "a b c d"},
+	} {
+		for _, scratch := range []*[]byte{nil, new([]byte)} {
+			in := []byte(tt.in)
+			if got := string(collapseWhitespace(in, scratch)); got != tt.want {
+				t.Errorf("%q: got %q, want %q", tt.in, got, tt.want)
+			}
+			if string(in) != tt.in {
+				t.Errorf("%q: input is modified to %q", tt.in, in)
+			}
+		}
+	}
+
+	// names which differ only in whitespace are one station
+	setFlags(t, "-collapse-whitespace")
+	got := formatResults(aggregate("New York;1.0\nNew   York;3.0\nNew\tYork;5.0\nOslo;0.0\n", 2), "brc")
+	if want := "{New York=1.0/3.0/5.0, Oslo=0.0/0.0/0.0}"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestRejects(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		args []string
+		data string
+		want string
+	}{
+		{
+			name: "out of range",
+			data: "A;1.0\nB;150.0\nA;-120.5\nC;2.0\n",
+			want: "B;150.0\nA;-120.5\n",
+		},
+		{
+			// rejected line is the input one, not the name after collapsing
+			name: "collapsed name",
+			args: []string{"-collapse-whitespace"},
+			data: "New   York;150.0\nNew York;1.0\nNew\t\tYork;-200.0\n",
+			want: "New   York;150.0\nNew\t\tYork;-200.0\n",
+		},
+		{
+			name: "quoted fields",
+			args: []string{"-quoted-fields"},
+			data: "\"A\";\"1.0\"\n\"B b\";\"999.9\"\n",
+			want: "\"B b\";\"999.9\"\n",
+		},
+		{
+			name: "inline records",
+			args: []string{"-record-inline-sep", ","},
+			data: "A;1.0,B;150.0,C;2.0\nD;-150.0\n",
+			want: "B;150.0\nD;-150.0\n",
+		},
+		{
+			name: "no delimiter",
+			data: "A;1.0\nB 2.0\nC;3.0\n",
+			want: "B 2.0\n",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			setFlags(t, append([]string{"-strict-range", "-skip-bad"}, tt.args...)...)
+			path := filepath.Join(t.TempDir(), "rejects.txt")
+			rejects = openRejects(path)
+			t.Cleanup(func() { rejects = nil })
+
+			data := []byte(tt.data)
+			scan(data, 0, len(data))
+			rejects.close()
+			if got, _ := os.ReadFile(path); string(got) != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			if string(data) != tt.data {
+				t.Errorf("input is modified to %q", data)
+			}
+		})
+	}
+}
+
+func TestRowsHint(t *testing.T) {
+	data := genMeasurements(20000, 300)
+	setFlags(t)
+	want := formatResults(aggregate(string(data), 3), "brc")
+	for _, tt := range []struct {
+		hint     string
+		stations int
+	}{
+		{"0", 0},
+		{"100", 100}, // fewer than real stations, maps grow past it
+		{"300", 300},
+		{"1000000000", maxStations},
+	} {
+		setFlags(t, "-rows-hint", tt.hint)
+		if got := stationsHint(); got != tt.stations {
+			t.Errorf("%s: got %d stations hint, want %d", tt.hint, got, tt.stations)
+		}
+		if got := formatResults(aggregate(string(data), 3), "brc"); got != want {
+			t.Errorf("%s: results differ from run without hint", tt.hint)
+		}
+	}
+}
+
+func TestEMA(t *testing.T) {
+	setFlags(t, "-ema", "0.5")
+	values := []float64{10, 20, 30, 10, -10, 0, 40}
+	var data strings.Builder
+	for _, v := range values {
+		fmt.Fprintf(&data, "A;%.1f\n", v)
+	}
+	// seeded with the first value, then ema += 0.5*(v-ema): 10, 15, 22.5, 16.25, 3.125, 1.5625, 20.78125
+	const want = 20.78125
+	if got := aggregate(data.String(), 1)["A"].ema; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// merge of consecutive parts continues EMA of the first one
+	for split := 1; split < len(values); split++ {
+		a, b := newAgg(values[0]), newAgg(values[split])
+		for _, v := range values[1:split] {
+			a.Add(v)
+		}
+		for _, v := range values[split+1:] {
+			b.Add(v)
+		}
+		a.Merge(b)
+		if math.Abs(a.ema-want) > 1e-9 {
+			t.Errorf("split at %d: got %v, want %v", split, a.ema, want)
+		}
+	}
+
+	if got := formatResults(aggregate(data.String(), 1), "csv"); got != "station,min,mean,max,ema\nA,-10.0,14.3,40.0,20.8\n" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestMaxLineLength(t *testing.T) {
+	long := strings.Repeat("x", 1<<20)
+	for _, tt := range []struct {
+		name, data string
+		err        string
+	}{
+		// no delimiter: key loop would run to the end of data
+		{"long key", "A;1.0\n" + long + "\nB;2.0\n", "record at byte 6 is longer than 64 bytes (-max-line-length)"},
+		// no newline: value loop would
+		{"long value", "A;1.0\nC;" + long + "\nB;2.0\n", "record at byte 6 is longer than 64 bytes (-max-line-length)"},
+		// 64 bytes with newline is still fine
+		{"at limit", "A;1.0\n" + strings.Repeat("y", 58) + ";3.0\nB;2.0\n", ""},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			data := []byte(tt.data)
+			setFlags(t, "-max-line-length", "64")
+			if tt.err != "" {
+				if msg := panicMessage(t, func() { scan(data, 0, len(data)) }); msg != tt.err {
+					t.Errorf("got %q, want %q", msg, tt.err)
+				}
+			} else if got := scan(data, 0, len(data)); len(got) != 3 {
+				t.Errorf("got %d stations, want 3", len(got))
+			}
+
+			setFlags(t, "-max-line-length", "64", "-skip-bad")
+			got := formatResults(scan(data, 0, len(data)), "brc")
+			if tt.err != "" && got != "{A=1.0/1.0/1.0, B=2.0/2.0/2.0}" {
+				t.Errorf("-skip-bad: got %s", got)
+			}
+		})
+	}
+}
+
+func TestNoDelimiter(t *testing.T) {
+	// key loop stops at newline, it doesn't take the next line into the key
+	data := []byte("A;1.0\nB 2.0\nC;3.0\n")
+	setFlags(t)
+	if msg, want := panicMessage(t, func() { scan(data, 0, len(data)) }), `record at byte 6 has no delimiter: "B 2.0"`; msg != want {
+		t.Errorf("got %q, want %q", msg, want)
+	}
+	setFlags(t, "-skip-bad")
+	if got := formatResults(scan(data, 0, len(data)), "brc"); got != "{A=1.0/1.0/1.0, C=3.0/3.0/3.0}" {
+		t.Errorf("-skip-bad: got %s", got)
+	}
+}
+
+func TestIQR(t *testing.T) {
+	for _, tt := range []struct {
+		values string
+		p25    float64
+		p75    float64
+	}{
+		// linear interpolation between closest ranks, like numpy.percentile
+		{"1 2 3 4 5 6 7 8 9", 3, 7},
+		{"1 2 3 4", 1.75, 3.25},
+		{"7 7 7 1 2", 2, 7},
+		{"-10 0 10 20 30 40 50 60", 7.5, 42.5},
+		{"5", 5, 5},
+	} {
+		setFlags(t, "-iqr")
+		var data strings.Builder
+		for _, v := range strings.Fields(tt.values) {
+			data.WriteString("A;" + v + ".0\n")
+		}
+		agg := aggregate(data.String(), 2)["A"]
+		values := agg.distinctValues()
+		p25, p75 := agg.quantile(values, 0.25), agg.quantile(values, 0.75)
+		if math.Abs(p25-tt.p25) > 1e-9 || math.Abs(p75-tt.p75) > 1e-9 {
+			t.Errorf("%s: got quartiles %v, %v, want %v, %v", tt.values, p25, p75, tt.p25, tt.p75)
+		}
+		if got := agg.iqr(); math.Abs(got-(tt.p75-tt.p25)) > 1e-9 {
+			t.Errorf("%s: got iqr %v, want %v", tt.values, got, tt.p75-tt.p25)
+		}
+	}
+
+	setFlags(t, "-iqr", "-format", "csv")
+	got := formatResults(aggregate("A;1.0\nA;2.0\nA;3.0\nA;4.0\nB;1.0\n", 1), "csv")
+	if want := "station,min,mean,max,iqr\nA,1.0,2.5,4.0,1.5\nB,1.0,1.0,1.0,0.0\n"; got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestByteStats(t *testing.T) {
+	// Oslo: 9 + 10 bytes, Hamburg: 13 bytes, St. John's without newline at the end: 15 + 1 added by terminateLastLine
+	const data = "Oslo;1.0\nHamburg;12.0\nOslo;-1.0\nSt. John's;15.2"
+	setFlags(t, "-byte-stats", "-format", "csv")
+	for _, workers := range []int{1, 3} {
+		results := aggregate(data, workers)
+		for key, want := range map[string]int{"Oslo": 19, "Hamburg": 13, "St. John's": 16} {
+			if got := results[key].bytes; got != want {
+				t.Errorf("%d workers, %s: got %d bytes, want %d", workers, key, got, want)
+			}
+		}
+	}
+	want := "station,min,mean,max,bytes\nHamburg,12.0,12.0,12.0,13\nOslo,-1.0,0.0,1.0,19\nSt. John's,15.2,15.2,15.2,16\n"
+	if got := formatResults(aggregate(data, 2), "csv"); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestNormalizeMinusZero(t *testing.T) {
+	const data = "A;-0.0\nA;0.0\nA;-0.0\nB;-0.0\nC;-1.5\nC;-0.0\n"
+	for _, tt := range []struct {
+		args    []string
+		signbit bool // of min and max of B
+	}{
+		{nil, true},
+		{[]string{"-normalize-minus-zero-input"}, false},
+	} {
+		setFlags(t, tt.args...)
+		results := aggregate(data, 1)
+		// rounded outputs print 0.0 either way, -0.0 stays only in aggregates (e.g. of -dump-map)
+		if got, want := formatResults(results, "brc"), "{A=0.0/0.0/0.0, B=0.0/0.0/0.0, C=-1.5/-0.7/0.0}"; got != want {
+			t.Errorf("%v: got %s, want %s", tt.args, got, want)
+		}
+		b := results["B"]
+		if math.Signbit(b.min) != tt.signbit || math.Signbit(b.max) != tt.signbit {
+			t.Errorf("%v: got min %v, max %v of B", tt.args, b.min, b.max)
+		}
+	}
+}
+
+func TestVariance(t *testing.T) {
+	// sample variance of 2, 4, 4, 4, 5, 5, 7, 9 is 32/7
+	setFlags(t, "-variance", "-chunk-bytes", "6")
+	if got := aggregate("A;2.0\nA;4.0\nA;4.0\nA;4.0\nA;5.0\nA;5.0\nA;7.0\nA;9.0\n", 3)["A"].variance(); math.Abs(got-32.0/7) > 1e-12 {
+		t.Errorf("got %v, want %v", got, 32.0/7)
+	}
+
+	// values far from zero relative to their spread, where sumSq - sum^2/n loses digits
+	r := rand.New(rand.NewSource(1))
+	var (
+		data   strings.Builder
+		values []float64
+	)
+	for i := 0; i < 20000; i++ {
+		v := 100000 + math.Round(r.NormFloat64()*30)/10
+		values = append(values, v)
+		fmt.Fprintf(&data, "A;%.1f\n", v)
+	}
+	// two-pass reference
+	mean := 0.0
+	for _, v := range values {
+		mean += v
+	}
+	mean /= float64(len(values))
+	want := 0.0
+	for _, v := range values {
+		want += (v - mean) * (v - mean)
+	}
+	want /= float64(len(values) - 1)
+
+	for _, chunkBytes := range []string{"0", "1000", "50000"} {
+		for _, workers := range []int{1, 4} {
+			setFlags(t, "-variance", "-chunk-bytes", chunkBytes)
+			if got := aggregate(data.String(), workers)["A"].variance(); math.Abs(got-want) > 1e-9*want {
+				t.Errorf("%s bytes chunks, %d workers: got %v, want %v", chunkBytes, workers, got, want)
+			}
+		}
+	}
+
+	// merge with empty aggregate on either side keeps state
+	setFlags(t, "-variance")
+	a := newAgg(1)
+	a.Add(3)
+	var empty Agg
+	empty.Merge(a)
+	a.Merge(Agg{})
+	if empty.variance() != 2 || a.variance() != 2 {
+		t.Errorf("got %v and %v after merge with empty, want 2", empty.variance(), a.variance())
+	}
+}
+
+func TestLayout(t *testing.T) {
+	for _, tt := range []struct {
+		args []string
+		data string
+	}{
+		{[]string{"-layout", "value:key"}, "12.3:Paris\n-4.0:Oslo\n1.7:Paris\n"},
+		{[]string{"-layout", "key:value"}, "Paris:12.3\nOslo:-4.0\nParis:1.7\n"},
+		// the default separator
+		{[]string{"-layout", "value;key"}, "12.3;Paris\n-4.0;Oslo\n1.7;Paris\n"},
+		// record separator is independent of field one
+		{[]string{"-layout", "value:key", "-record-inline-sep", ","}, "12.3:Paris,-4.0:Oslo\n1.7:Paris\n"},
+	} {
+		setFlags(t, tt.args...)
+		if got, want := formatResults(aggregate(tt.data, 2), "brc"), "{Oslo=-4.0/-4.0/-4.0, Paris=1.7/7.0/12.3}"; got != want {
+			t.Errorf("%v: got %s, want %s", tt.args, got, want)
+		}
+	}
+
+	for layout, want := range map[string]struct {
+		valueFirst bool
+		sep        byte
+		ok         bool
+	}{
+		"value:key":  {true, ':', true},
+		"key|value":  {false, '|', true},
+		"key::value": {},
+		"value:":     {},
+		"key;key":    {},
+		"":           {},
+	} {
+		valueFirst, sep, ok := parseLayout(layout)
+		if valueFirst != want.valueFirst || sep != want.sep || ok != want.ok {
+			t.Errorf("%q: got %v, %q, %v", layout, valueFirst, sep, ok)
+		}
+	}
+}
+
+func TestHashKeys(t *testing.T) {
+	const data = "Hamburg;12.0\nOslo;-3.5\nHamburg;-1.0\n"
+	setFlags(t)
+	plain := aggregate(data, 1)
+	setFlags(t, "-hash-keys")
+	hashed := aggregate(data, 2)
+
+	if len(hashed) != len(plain) {
+		t.Fatalf("got %d stations, want %d", len(hashed), len(plain))
+	}
+	for key, agg := range plain {
+		h := hashKey(key)
+		// 8 bytes of SHA-256 in hex, the same for the same name in every run
+		if len(h) != 16 || h != hashKey(key) || strings.Contains(h, key) {
+			t.Errorf("%s: got hash %q", key, h)
+		}
+		if got, ok := hashed[h]; !ok || got.min != agg.min || got.max != agg.max || got.sum != agg.sum || got.count != agg.count {
+			t.Errorf("%s: got %+v, want %+v", key, got, agg)
+		}
+	}
+	// printf Hamburg | sha256sum | cut -c1-16
+	if got, want := hashKey("Hamburg"), "47afcff3dcb9e989"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	if hashKey("Hamburg") == hashKey("hamburg") {
+		t.Error("different names have the same hash")
+	}
+}
+
+func TestCV(t *testing.T) {
+	// mean 5, sample stddev sqrt(32/7)
+	const data = "A;2.0\nA;4.0\nA;4.0\nA;4.0\nA;5.0\nA;5.0\nA;7.0\nA;9.0\nZ;-1.5\nZ;1.5\nS;3.0\n"
+	for _, args := range [][]string{{"-cv"}, {"-cv", "-variance"}} {
+		setFlags(t, args...)
+		results := aggregate(data, 2)
+		if got, want := results["A"].cv(), math.Sqrt(32.0/7)/5; math.Abs(got-want) > 1e-12 {
+			t.Errorf("%v: got %v, want %v", args, got, want)
+		}
+		// zero mean and single value have no cv
+		if got := results["Z"].cv(); !math.IsNaN(got) {
+			t.Errorf("%v: got cv %v of zero mean, want NaN", args, got)
+		}
+		if got := results["S"].cv(); !math.IsNaN(got) {
+			t.Errorf("%v: got cv %v of single value, want NaN", args, got)
+		}
+	}
+
+	// cv is relative, so it has four decimals instead of one
+	setFlags(t, "-cv", "-format", "csv")
+	results := aggregate(data, 2)
+	if got, want := formatResults(results, "csv"), "station,min,mean,max,cv\nA,2.0,5.0,9.0,0.4276\nS,3.0,3.0,3.0,NaN\nZ,-1.5,0.0,1.5,NaN\n"; got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+	if got := formatResults(results, "json"); !strings.Contains(got, `"cv": 0.4276`) || !strings.Contains(got, `"cv": null`) {
+		t.Errorf("got %s", got)
+	}
+}
+
+func TestValueScale(t *testing.T) {
+	setFlags(t, "-value-scale", "0.1")
+	results := aggregate("A;123\nA;-45\nB;0\nB;7\nA;-1000\n", 2)
+	if got, want := formatResults(results, "brc"), "{A=-100.0/-30.7/12.3, B=0.0/0.4/0.7}"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	// divided by power of ten, 123 is the same float as 12.3
+	if a := results["A"]; a.max != 12.3 || a.min != -100 || a.count != 3 {
+		t.Errorf("got %+v", a)
+	}
+	for value, want := range map[string]float64{"1": 0.1, "-3": -0.3, "997": 99.7, "-1": -0.1} {
+		if got := scaledInt([]byte(value)); got != want {
+			t.Errorf("%s: got %v, want %v", value, got, want)
+		}
+	}
+
+	// other scales are multiplied
+	setFlags(t, "-value-scale", "2.5")
+	if got, want := formatResults(aggregate("A;4\nA;-2\n", 1), "brc"), "{A=-5.0/2.5/10.0}"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	setFlags(t, "-value-scale", "0.1")
+	for _, value := range []string{"12.3", "-", "1e3"} {
+		if msg := panicMessage(t, func() { scaledInt([]byte(value)) }); !strings.Contains(msg, value) {
+			t.Errorf("%s: got panic %s", value, msg)
+		}
+	}
+
+	dir := t.TempDir()
+	for _, scale := range []string{"0", "NaN", "+Inf"} {
+		if out, code := runMain(t, dir, "-value-scale", scale); code != 2 || !strings.Contains(out, "-value-scale") {
+			t.Errorf("%s: got exit code %d, output %s", scale, code, out)
+		}
+	}
+}
@@ -0,0 +1,391 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// formats maps -format names to functions writing results of stations in keys order (see sortedKeys)
+var formats = map[string]func(data map[string]Agg, keys []string, w io.Writer){
+	"brc":      printResults,
+	"table":    printTable,
+	"json":     printJSON,
+	"csv":      printCSV,
+	"template": printTemplate,
+	"flat":     printFlat,
+}
+
+// fileFormats maps -format names to functions writing results into file at path.
+// They are for formats which can't be streamed into io.Writer (e.g. databases)
+var fileFormats = map[string]func(data map[string]Agg, keys []string, path string){}
+
+// formatExts maps output file extensions to formats
+var formatExts = map[string]string{
+	".json": "json",
+	".csv":  "csv",
+}
+
+// fixedFields are fields of formats whose layout doesn't depend on enabled aggregates
+var fixedFields = map[string][]field{
+	"brc": baseFields,
+}
+
+func isFormat(name string) bool {
+	_, ok := formats[name]
+	if !ok {
+		_, ok = fileFormats[name]
+	}
+	return ok
+}
+
+// formatNames returns sorted list of known formats for usage message
+func formatNames() string {
+	names := make([]string, 0, len(formats)+len(fileFormats))
+	for name := range formats {
+		names = append(names, name)
+	}
+	for name := range fileFormats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// notAvailable is printed instead of values of station which is absent in data
+const notAvailable = "N/A"
+
+// sortedKeys returns station names in output order: byte order or by opts.collator rules if it's set.
+// With -keys-file it's the file order, and stations may be absent in data.
+// globalKey (with -global) always goes last
+func sortedKeys(data map[string]Agg) []string {
+	var keys []string
+	if opts.keys != nil {
+		keys = append(keys, opts.keys...)
+	} else {
+		keys = make([]string, 0, len(data))
+		for key := range data {
+			if opts.global && key == globalKey {
+				continue
+			}
+			keys = append(keys, key)
+		}
+		if opts.collator != nil {
+			opts.collator.SortStrings(keys)
+		} else {
+			sort.Strings(keys)
+		}
+	}
+	if _, ok := data[globalKey]; ok && opts.global {
+		keys = append(keys, globalKey)
+	}
+	return keys
+}
+
+// field is a column of output
+type field struct {
+	name  string
+	typ   string // string, float (one decimal), float4 (four decimals, see precise) or int
+	value func(key string, v Agg) any
+}
+
+// baseFields are written by every format
+var baseFields = []field{
+	{"station", "string", func(key string, _ Agg) any { return key }},
+	{"min", "float", func(_ string, v Agg) any { return round(v.min) }},
+	{"mean", "float", func(_ string, v Agg) any { return round(v.sum / float64(v.count)) }},
+	{"max", "float", func(_ string, v Agg) any { return round(v.max) }},
+}
+
+// outputFields returns columns of output in order, depending on enabled aggregates
+func outputFields() []field {
+	fields := append([]field(nil), baseFields...)
+	if opts.geomean {
+		fields = append(fields, field{"geomean", "float", func(_ string, v Agg) any {
+			return round(math.Exp(v.sumLog / float64(v.count)))
+		}})
+	}
+	if opts.harmonic {
+		fields = append(fields, field{"harmonic", "float", func(_ string, v Agg) any {
+			return round(float64(v.count) / v.sumRecip)
+		}})
+	}
+	if opts.variance {
+		fields = append(fields, field{"variance", "float", func(_ string, v Agg) any { return round(v.variance()) }})
+	}
+	if opts.cv {
+		fields = append(fields, field{"cv", "float4", func(_ string, v Agg) any { return precise(v.cv()) }})
+	}
+	if opts.ema > 0 {
+		fields = append(fields, field{"ema", "float", func(_ string, v Agg) any { return round(v.ema) }})
+	}
+	if opts.trimmedMean > 0 {
+		fields = append(fields, field{"trimmed_mean", "float", func(_ string, v Agg) any {
+			return round(v.trimmedMean(opts.trimmedMean))
+		}})
+	}
+	if opts.iqr {
+		fields = append(fields, field{"iqr", "float", func(_ string, v Agg) any { return round(v.iqr()) }})
+	}
+	if opts.presence {
+		fields = append(fields, field{"presence", "string", func(_ string, v Agg) any { return formatPresence(v.presence) }})
+	}
+	if opts.byteStats {
+		fields = append(fields, field{"bytes", "int", func(_ string, v Agg) any { return v.bytes }})
+	}
+	if opts.confidence > 0 {
+		fields = append(fields,
+			field{"ci_low", "float", func(_ string, v Agg) any {
+				low, _ := v.confidenceInterval(opts.confidence)
+				return round(low)
+			}},
+			field{"ci_high", "float", func(_ string, v Agg) any {
+				_, high := v.confidenceInterval(opts.confidence)
+				return round(high)
+			}},
+		)
+	}
+	return fields
+}
+
+// formatFields returns fields written by format
+func formatFields(format string) []field {
+	if fields, ok := fixedFields[format]; ok {
+		return fields
+	}
+	return outputFields()
+}
+
+// precise is a float field value printed with four decimals instead of one,
+// for relative metrics like -cv which are mostly below 1 and would be lost in rounding
+type precise float64
+
+// formatValue formats field value, floats are already rounded to one decimal
+func formatValue(value any) string {
+	switch f := value.(type) {
+	case float64:
+		return fmt.Sprintf("%.1f", f)
+	case precise:
+		return fmt.Sprintf("%.4f", float64(f))
+	}
+	return fmt.Sprint(value)
+}
+
+// toFloat returns float64 of float field value
+func toFloat(value any) float64 {
+	if p, ok := value.(precise); ok {
+		return float64(p)
+	}
+	return value.(float64)
+}
+
+// printSchema writes format name and its fields with types, one per line
+func printSchema(format string, w io.Writer) {
+	fmt.Fprintf(w, "format %s\n", format)
+	for _, f := range formatFields(format) {
+		fmt.Fprintf(w, "%s %s\n", f.name, f.typ)
+	}
+}
+
+// printTable writes column-aligned fields per station, for humans
+func printTable(data map[string]Agg, keys []string, w io.Writer) {
+	fields := outputFields()
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for i, f := range fields {
+		if i > 0 {
+			tw.Write([]byte{'\t'})
+		}
+		tw.Write([]byte(f.name))
+	}
+	tw.Write([]byte{'\n'})
+
+	for _, key := range keys {
+		v, ok := data[key]
+		for i, f := range fields {
+			if i > 0 {
+				tw.Write([]byte{'\t'})
+			}
+			if i > 0 && !ok {
+				tw.Write([]byte(notAvailable))
+				continue
+			}
+			tw.Write([]byte(formatValue(f.value(key, v))))
+		}
+		tw.Write([]byte{'\n'})
+	}
+	tw.Flush()
+}
+
+// printJSON writes array of objects with fields per station, values of absent stations are null
+func printJSON(data map[string]Agg, keys []string, w io.Writer) {
+	fields := outputFields()
+	bw := bufio.NewWriter(w)
+	bw.WriteString("[")
+	for i, key := range keys {
+		if i > 0 {
+			bw.WriteString(",")
+		}
+		bw.WriteString("\n  ")
+		v, ok := data[key]
+		writeJSONObject(bw, fields, key, v, ok)
+	}
+	bw.WriteString("\n]\n")
+	if err := bw.Flush(); err != nil {
+		panic(err)
+	}
+}
+
+// printJSONLines writes object per station per line and flushes every line,
+// so consumer of a pipe gets stations as soon as they are written
+func printJSONLines(data map[string]Agg, keys []string, w io.Writer) {
+	fields := outputFields()
+	bw := bufio.NewWriter(w)
+	for _, key := range keys {
+		v, ok := data[key]
+		writeJSONObject(bw, fields, key, v, ok)
+		bw.WriteByte('\n')
+		if err := bw.Flush(); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// writeJSONObject writes fields of station as JSON object, values of absent station are null
+func writeJSONObject(bw *bufio.Writer, fields []field, key string, v Agg, ok bool) {
+	bw.WriteString("{")
+	for j, f := range fields {
+		if j > 0 {
+			bw.WriteString(", ")
+		}
+		name, _ := json.Marshal(f.name)
+		bw.Write(name)
+		bw.WriteString(": ")
+		if j > 0 && !ok {
+			bw.WriteString("null")
+			continue
+		}
+		switch value := f.value(key, v).(type) {
+		case float64, precise:
+			if f := toFloat(value); math.IsNaN(f) || math.IsInf(f, 0) {
+				bw.WriteString("null") // e.g. confidence interval of single reading
+			} else {
+				bw.WriteString(formatValue(value))
+			}
+		case string:
+			b, _ := json.Marshal(value)
+			bw.Write(b)
+		default:
+			bw.WriteString(formatValue(value))
+		}
+	}
+	bw.WriteString("}")
+}
+
+// printFlat writes `station.field value` line per field, stations in output order,
+// so diff of two results shows exactly the metrics that changed
+func printFlat(data map[string]Agg, keys []string, w io.Writer) {
+	fields := outputFields()
+	bw := bufio.NewWriter(w)
+	for _, key := range keys {
+		v, ok := data[key]
+		for _, f := range fields[1:] {
+			value := notAvailable
+			if ok {
+				value = formatValue(f.value(key, v))
+			}
+			fmt.Fprintf(bw, "%s.%s %s\n", key, f.name, value)
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		panic(err)
+	}
+}
+
+// printCSV writes header and fields per station, values of absent stations are empty
+func printCSV(data map[string]Agg, keys []string, w io.Writer) {
+	fields := outputFields()
+	cw := csv.NewWriter(w)
+	row := make([]string, len(fields))
+	for i, f := range fields {
+		row[i] = f.name
+	}
+	cw.Write(row)
+
+	for _, key := range keys {
+		v, ok := data[key]
+		for i, f := range fields {
+			if i > 0 && !ok {
+				row[i] = ""
+				continue
+			}
+			row[i] = formatValue(f.value(key, v))
+		}
+		cw.Write(row)
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		panic(err)
+	}
+}
+
+func dumpMapToFile(data map[string]Agg, path string) {
+	writeFileAtomic(path, opts.noClobber, func(w io.Writer) {
+		dumpMap(data, w)
+	})
+}
+
+// workerMapsDumps counts mapScan calls dumped by dumpWorkerMaps
+var workerMapsDumps int
+
+// dumpWorkerMaps writes map of every worker to dir/map-<call>-worker-<i>.txt in dumpMap format.
+// There is a call per mapScan: one for whole input, but one per chunk with -stream or per segment with -checkpoint
+func dumpWorkerMaps(dir string, results []map[string]Agg) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		panic(err)
+	}
+	for i, res := range results {
+		dumpMapToFile(res, filepath.Join(dir, fmt.Sprintf("map-%03d-worker-%d.txt", workerMapsDumps, i)))
+	}
+	workerMapsDumps++
+}
+
+// dumpMap writes raw Agg fields with full precision, helps to debug mean/rounding discrepancies
+func dumpMap(data map[string]Agg, w io.Writer) {
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		v := data[key]
+		fmt.Fprintf(w, "%s sum=%v count=%d min=%v max=%v", key, v.sum, v.count, v.min, v.max)
+		if opts.geomean {
+			fmt.Fprintf(w, " sumLog=%v", v.sumLog)
+		}
+		fmt.Fprintf(w, " sumSq=%v", v.sumSq)
+		if opts.harmonic {
+			fmt.Fprintf(w, " sumRecip=%v", v.sumRecip)
+		}
+		if opts.ema > 0 {
+			fmt.Fprintf(w, " ema=%v first=%v", v.ema, v.first)
+		}
+		if opts.byteStats {
+			fmt.Fprintf(w, " bytes=%d", v.bytes)
+		}
+		if opts.presence {
+			fmt.Fprintf(w, " presence=%s", formatPresence(v.presence))
+		}
+		if opts.keepCounts {
+			fmt.Fprintf(w, " distinct=%d", len(v.counts))
+		}
+		fmt.Fprintln(w)
+	}
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTableFormat(t *testing.T) {
+	setFlags(t)
+	results := aggregate("Hamburg;12.0\nBulawayo;8.9\nPalembang;38.8\nHamburg;-3.4\nSt. John's;15.2\n", 1)
+	want := "" +
+		"station     min   mean  max\n" +
+		"Bulawayo    8.9   8.9   8.9\n" +
+		"Hamburg     -3.4  4.3   12.0\n" +
+		"Palembang   38.8  38.8  38.8\n" +
+		"St. John's  15.2  15.2  15.2\n"
+	if got := formatResults(results, "table"); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestDumpMap(t *testing.T) {
+	setFlags(t)
+	var b bytes.Buffer
+	dumpMap(aggregate("B;0.1\nA;12.25\nB;0.2\nA;-2.5\nB;0.3\n", 1), &b)
+	// sums are not rounded: 0.1+0.2+0.3 is not 0.6 in float64
+	want := "" +
+		"A sum=9.75 count=2 min=-2.5 max=12.25 sumSq=156.3125\n" +
+		"B sum=0.6000000000000001 count=3 min=0.1 max=0.3 sumSq=0.14\n"
+	if b.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", b.String(), want)
+	}
+}
+
+func TestLocaleSort(t *testing.T) {
+	data := "Zürich;1.0\nÉvian;1.0\nÄgypten;1.0\nEssen;1.0\nApfel;1.0\nBad Tölz;1.0\n"
+	for _, tt := range []struct {
+		flags []string
+		want  string
+	}{
+		{nil, "Apfel, Bad Tölz, Essen, Zürich, Ägypten, Évian"}, // bytes order, accented letters are after z
+		{[]string{"-locale", "de"}, "Ägypten, Apfel, Bad Tölz, Essen, Évian, Zürich"},
+		{[]string{"-locale", "fr"}, "Ägypten, Apfel, Bad Tölz, Essen, Évian, Zürich"},
+	} {
+		setFlags(t, tt.flags...)
+		got := strings.Join(sortedKeys(aggregate(data, 1)), ", ")
+		if got != tt.want {
+			t.Errorf("%v: got %s, want %s", tt.flags, got, tt.want)
+		}
+	}
+}
+
+func TestSchema(t *testing.T) {
+	for _, tt := range []struct {
+		flags []string
+		want  string
+	}{
+		{[]string{"-format", "json"}, "format json\nstation string\nmin float\nmean float\nmax float\n"},
+		{
+			[]string{"-format", "csv", "-iqr", "-variance", "-cv", "-confidence", "0.95"},
+			"format csv\nstation string\nmin float\nmean float\nmax float\n" +
+				"variance float\ncv float4\niqr float\nci_low float\nci_high float\n",
+		},
+		{
+			// brc line has fixed fields whatever is enabled
+			[]string{"-iqr", "-variance"},
+			"format brc\nstation string\nmin float\nmean float\nmax float\n",
+		},
+		{
+			[]string{"-output", "result.json", "-trimmed-mean", "0.1", "-byte-stats"},
+			"format json\nstation string\nmin float\nmean float\nmax float\ntrimmed_mean float\nbytes int\n",
+		},
+	} {
+		setFlags(t, tt.flags...)
+		var b bytes.Buffer
+		printSchema(opts.format, &b)
+		if b.String() != tt.want {
+			t.Errorf("%v: got\n%s\nwant\n%s", tt.flags, b.String(), tt.want)
+		}
+	}
+}
+
+func TestKeysFile(t *testing.T) {
+	keys := writeFile(t, "keys.txt", "Oslo\r\nNowhere\n\nBerlin\n")
+	setFlags(t, "-keys-file", keys)
+	results := aggregate("Berlin;1.0\nParis;2.0\nOslo;-3.0\n", 1)
+
+	if got, want := formatResults(results, "brc"), "{Oslo=-3.0/-3.0/-3.0, Nowhere=N/A, Berlin=1.0/1.0/1.0}"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	want := "station,min,mean,max\nOslo,-3.0,-3.0,-3.0\nNowhere,,,\nBerlin,1.0,1.0,1.0\n"
+	if got := formatResults(results, "csv"); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestMultipleOutputs(t *testing.T) {
+	dir := t.TempDir()
+	paths := map[string]string{
+		"json": filepath.Join(dir, "result.json"),
+		"csv":  filepath.Join(dir, "result.csv"),
+		"brc":  filepath.Join(dir, "result.txt"),
+	}
+	setFlags(t, "-output", paths["json"], "-output", paths["csv"], "-output", paths["brc"])
+	results := aggregate("Oslo;-3.5\nHamburg;12.0\nHamburg;-1.0\n", 1)
+	writeResultsToFile(results)
+
+	for format, path := range paths {
+		got, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := formatResults(results, format); string(got) != want {
+			t.Errorf("%s: got\n%s\nwant %s output\n%s", path, got, format, want)
+		}
+	}
+	// the first output decides format of -schema and -assert-output
+	if opts.format != "json" {
+		t.Errorf("got format %s, want json", opts.format)
+	}
+}
+
+func TestOutputFormats(t *testing.T) {
+	for _, tt := range []struct {
+		args    []string
+		formats []string
+	}{
+		{nil, []string{"brc"}},
+		{[]string{"-output", "a.json", "-output", "b.csv", "-output", "c.out"}, []string{"json", "csv", "brc"}},
+		// -format applies to every output whatever its extension
+		{[]string{"-format", "csv", "-output", "a.json", "-output", "b.txt"}, []string{"csv", "csv"}},
+	} {
+		setFlags(t, tt.args...)
+		var formats []string
+		for _, out := range opts.outputs {
+			formats = append(formats, out.format)
+		}
+		if strings.Join(formats, ",") != strings.Join(tt.formats, ",") {
+			t.Errorf("%v: got %v, want %v", tt.args, formats, tt.formats)
+		}
+	}
+}
+
+// writesRecorder keeps every Write call separately
+type writesRecorder struct{ writes []string }
+
+func (w *writesRecorder) Write(p []byte) (int, error) {
+	w.writes = append(w.writes, string(p))
+	return len(p), nil
+}
+
+func TestStdoutJSONLines(t *testing.T) {
+	setFlags(t, "-stdout-jsonl", "-keys-file", writeFile(t, "keys.txt", "Oslo\nAbsent\nHamburg\n"))
+	if diag != os.Stderr {
+		t.Error("run info is not moved to stderr")
+	}
+	results := aggregate("Oslo;-3.5\nHamburg;12.0\nHamburg;-1.0\n", 1)
+
+	var w writesRecorder
+	printJSONLines(results, sortedKeys(results), &w)
+	want := []string{
+		`{"station": "Oslo", "min": -3.5, "mean": -3.5, "max": -3.5}` + "\n",
+		`{"station": "Absent", "min": null, "mean": null, "max": null}` + "\n",
+		`{"station": "Hamburg", "min": -1.0, "mean": 5.5, "max": 12.0}` + "\n",
+	}
+	// line per write: it's flushed as soon as it's formatted
+	if strings.Join(w.writes, "|") != strings.Join(want, "|") {
+		t.Errorf("got writes %q, want %q", w.writes, want)
+	}
+	for _, line := range w.writes {
+		var v map[string]any
+		if err := json.Unmarshal([]byte(line), &v); err != nil {
+			t.Errorf("%q: %s", line, err)
+		}
+	}
+}
+
+func TestFlatFormat(t *testing.T) {
+	setFlags(t, "-format", "flat")
+	got := formatResults(aggregate("Oslo;-3.5\nHamburg;12.0\nHamburg;-1.0\n", 1), "flat")
+	want := "" +
+		"Hamburg.min -1.0\n" +
+		"Hamburg.mean 5.5\n" +
+		"Hamburg.max 12.0\n" +
+		"Oslo.min -3.5\n" +
+		"Oslo.mean -3.5\n" +
+		"Oslo.max -3.5\n"
+	if got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+
+	// change of one value is a change of one line
+	before := strings.Split(got, "\n")
+	after := strings.Split(formatResults(aggregate("Oslo;-3.5\nHamburg;12.5\nHamburg;-1.0\n", 1), "flat"), "\n")
+	var changed []string
+	for i := range before {
+		if before[i] != after[i] {
+			changed = append(changed, after[i])
+		}
+	}
+	if strings.Join(changed, "|") != "Hamburg.mean 5.8|Hamburg.max 12.5" {
+		t.Errorf("got changed lines %q", changed)
+	}
+
+	setFlags(t, "-format", "flat", "-variance", "-keys-file", writeFile(t, "keys.txt", "Nowhere\n"))
+	if got, want := formatResults(aggregate("A;1.0\n", 1), "flat"),
+		"Nowhere.min N/A\nNowhere.mean N/A\nNowhere.max N/A\nNowhere.variance N/A\n"; got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestDumpWorkerMaps(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "maps")
+	setFlags(t, "-dump-worker-maps", dir, "-chunk-bytes", "256")
+	saved := workerMapsDumps
+	workerMapsDumps = 0
+	t.Cleanup(func() { workerMapsDumps = saved })
+
+	data := genMeasurements(1000, 20)
+	for call := 0; call < 2; call++ {
+		results := mapScan(data, scan, 3)
+		rows := 0
+		for i, res := range results {
+			path := filepath.Join(dir, fmt.Sprintf("map-%03d-worker-%d.txt", call, i))
+			got, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var want bytes.Buffer
+			dumpMap(res, &want)
+			if string(got) != want.String() {
+				t.Errorf("%s: got\n%s\nwant\n%s", path, got, want.String())
+			}
+			rows += countRows(res)
+		}
+		// partial maps of workers add up to the whole input
+		if rows != 1000 {
+			t.Errorf("call %d: worker maps have %d rows, want 1000", call, rows)
+		}
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 6 {
+		t.Errorf("got %d files, want 3 per mapScan call", len(entries))
+	}
+}
+
+func TestFastOutput(t *testing.T) {
+	setFlags(t)
+	results := aggregate(string(genMeasurements(20000, 3000)), 2)
+	// rounds to -0.0, mean of many values, name which is not ASCII
+	results["Zero"] = Agg{min: -0.04, max: 0.04, sum: -0.04, count: 2}
+	results["Tromsø"] = Agg{min: -99.95, max: 99.95, sum: 1e6, count: 3}
+	want := formatResults(results, "brc")
+
+	setFlags(t, "-fast-output")
+	var w writesRecorder
+	printResults(results, sortedKeys(results), &w)
+	if got := strings.Join(w.writes, ""); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+	// output is written in 64KB pieces, not per station
+	if len(w.writes) > len(want)/(64<<10)+1 {
+		t.Errorf("got %d writes of %d bytes", len(w.writes), len(want))
+	}
+
+	keys := writeFile(t, "keys.txt", "Nowhere\nZero\n")
+	setFlags(t, "-keys-file", keys)
+	want = formatResults(results, "brc")
+	setFlags(t, "-keys-file", keys, "-fast-output")
+	if got := formatResults(results, "brc"); got != want || !strings.Contains(got, "Nowhere=N/A") {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
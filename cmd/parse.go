@@ -0,0 +1,472 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxStations is the limit of distinct stations in 1BRC input
+const maxStations = 10000
+
+// stationsHint returns capacity for maps keyed by station: there can't be more stations than rows,
+// nor more than maxStations, so with accurate -rows-hint maps never grow
+func stationsHint() int {
+	return min(opts.rowsHint, maxStations)
+}
+
+// scan reads chunk of data, chunk [i, end) must be record aligned (see chunkBoundaries).
+// Station names are allocated only once per new station:
+// a map lookup with string(key) conversion doesn't allocate
+func scan(data []byte, i int, end int) map[string]Agg {
+	m := make(map[string]*Agg, stationsHint())
+	// aggregates of new stations are taken from slab while it has room, instead of allocation per station
+	slab := make([]Agg, 0, stationsHint())
+	var (
+		key   []byte
+		value float64
+
+		ts       time.Time
+		keyBuf   []byte // scratch for composed keys
+		spaceBuf []byte // scratch for keys with collapsed whitespace
+
+		agg *Agg
+
+		filter = make(stationFilter, stationsHint())
+
+		start    int
+		rejected []byte
+	)
+
+	var times *chunkTimes
+	if opts.monotonic != "" {
+		times = newChunkTimes(i)
+	}
+	var dense *denseTable
+	if opts.denseKeys > 0 {
+		dense = newDenseTable()
+	}
+
+	for i < end {
+		if opts.sample {
+			var skip bool
+			if i, skip = skipUnsampled(data, i); skip {
+				continue
+			}
+		}
+		start = i
+		if opts.timeBucket > 0 || times != nil {
+			ts, i = parseTimestamp(data, i)
+		}
+		key, value, i = parseRecord(data, i, &spaceBuf)
+		if times != nil {
+			times.add(data, start, i, ts, key)
+		}
+
+		if opts.filter && !filter.keep(key) {
+			continue
+		}
+		if !checkValue(key, value) {
+			if rejects != nil {
+				rejected = appendReject(rejected, data, start, i)
+			}
+			continue
+		}
+
+		if opts.timeBucket > 0 {
+			keyBuf = bucketKey(keyBuf[:0], ts, key)
+			key = keyBuf
+		}
+
+		if shared != nil {
+			shared.add(key, value, i-start)
+			continue
+		}
+
+		if dense != nil {
+			if agg = dense.add(key, value); agg != nil {
+				if opts.byteStats {
+					agg.bytes += i - start
+				}
+				continue
+			}
+		}
+
+		// update value
+		agg = m[string(key)]
+		if agg != nil {
+			agg.Add(value)
+		} else if len(slab) < cap(slab) {
+			slab = append(slab, newAgg(value))
+			agg = &slab[len(slab)-1]
+			m[string(key)] = agg
+		} else {
+			newValue := newAgg(value)
+			agg = &newValue
+			m[string(key)] = agg
+		}
+		if opts.byteStats {
+			agg.bytes += i - start
+		}
+	}
+
+	if rejects != nil {
+		rejects.write(rejected)
+	}
+	if times != nil {
+		addChunkTimes(times)
+	}
+	out := derefMap(m)
+	if dense != nil {
+		dense.mergeInto(out)
+	}
+	return out
+}
+
+// parseRecord parses `key;value\n` record which starts at data[i],
+// key is terminated by the first of opts.delimiters,
+// value by newline or -record-inline-sep (then line has several records).
+// With -layout value:key fields are swapped, and it's value which ends at the delimiter.
+// Returns key (slice of data, no copy), value and position of the next record.
+// Key changed by -collapse-whitespace is written to scratch instead (see collapseWhitespace)
+func parseRecord(data []byte, i int, scratch *[]byte) (key []byte, value float64, next int) {
+	keyStart := i
+	limit := len(data)
+	if opts.maxLineLength > 0 {
+		limit = min(i+opts.maxLineLength, len(data))
+	}
+
+	for i < limit && !opts.isDelimiter[data[i]] && data[i] != '\n' {
+		i++
+	}
+	if i == limit {
+		return longRecord(data, keyStart)
+	}
+	if data[i] == '\n' {
+		return noDelimiter(data, keyStart, i)
+	}
+	first := data[keyStart:i]
+	i++
+
+	valueStart := i
+	if sep := opts.inlineSep; sep == 0 {
+		for i < limit && data[i] != '\n' {
+			i++
+		}
+	} else {
+		for i < limit && data[i] != '\n' && data[i] != sep {
+			i++
+		}
+	}
+	if i == limit {
+		return longRecord(data, keyStart)
+	}
+	key, valueBytes := first, data[valueStart:i]
+	if opts.valueFirst {
+		key, valueBytes = valueBytes, key
+	}
+
+	if opts.quotedFields {
+		key = unquote(key)
+		valueBytes = unquote(valueBytes)
+	}
+	if opts.collapseSpace {
+		key = collapseWhitespace(key, scratch)
+	}
+
+	if len(valueBytes) == 0 {
+		return key, missingValue(key), i + 1
+	}
+	switch {
+	case opts.valueScale != 0:
+		value = scaledInt(valueBytes)
+	case opts.exactFloat:
+		value = exactFloat(valueBytes)
+	default:
+		value = fastFloat(valueBytes)
+	}
+	if opts.normMinusZero {
+		value += 0 // -0 + 0 is +0, any other value stays the same
+	}
+	if opts.valueTransform != "" {
+		value = value*opts.scale + opts.offset
+	}
+	if opts.clamp {
+		value = min(max(value, opts.minValue), opts.maxValue)
+	}
+
+	return key, value, i + 1
+}
+
+// parseLayout parses -layout: field names key and value in the record order, separated by single byte
+func parseLayout(layout string) (valueFirst bool, sep byte, ok bool) {
+	switch {
+	case len(layout) == len("key;value") && strings.HasPrefix(layout, "key") && strings.HasSuffix(layout, "value"):
+		return false, layout[3], true
+	case len(layout) == len("value;key") && strings.HasPrefix(layout, "value") && strings.HasSuffix(layout, "key"):
+		return true, layout[5], true
+	}
+	return false, 0, false
+}
+
+// longRecord handles record at data[start] without delimiter or newline within opts.maxLineLength
+// (or before the end of data): it fails, or with -skip-bad the rest of the line is skipped.
+// NaN value makes checkValue drop the record
+func longRecord(data []byte, start int) (key []byte, value float64, next int) {
+	if opts.maxLineLength == 0 {
+		panic(fmt.Errorf("record at byte %d is not terminated", start))
+	}
+	if !opts.skipBad {
+		panic(fmt.Errorf("record at byte %d is longer than %d bytes (-max-line-length)", start, opts.maxLineLength))
+	}
+	next = len(data)
+	if nl := bytes.IndexByte(data[start:], '\n'); nl >= 0 {
+		next = start + nl + 1
+	}
+	return nil, math.NaN(), next
+}
+
+// noDelimiter handles line data[start:end] without delimiter: it fails, or with -skip-bad it's skipped.
+// NaN value makes checkValue drop the record
+func noDelimiter(data []byte, start, end int) (key []byte, value float64, next int) {
+	if !opts.skipBad {
+		panic(fmt.Errorf("record at byte %d has no delimiter: %q", start, data[start:end]))
+	}
+	return nil, math.NaN(), end + 1
+}
+
+// missingValue returns value of record with empty value according to opts.missingValue.
+// NaN means record has to be skipped (checkValue drops it)
+func missingValue(key []byte) float64 {
+	switch opts.missingValue {
+	case "skip":
+		return math.NaN()
+	case "zero":
+		return 0
+	default:
+		panic(fmt.Errorf("missing value of %q", key))
+	}
+}
+
+// checkValue validates parsed record according to opts.
+// Returns false if record has to be skipped, panics if it's bad and skipping is not allowed
+func checkValue(key []byte, value float64) bool {
+	if value != value { // NaN, missing value to skip
+		return false
+	}
+	if len(key) == 0 {
+		switch opts.emptyKey {
+		case "skip":
+			return false
+		case "error":
+			panic(fmt.Errorf("record with empty station name (value %.1f)", value))
+		}
+	}
+	if opts.strictRange && (value < opts.rangeMin || value > opts.rangeMax) {
+		if opts.skipBad {
+			return false
+		}
+		panic(fmt.Errorf("value %.1f of %q is out of range [%.1f, %.1f]", value, key, opts.rangeMin, opts.rangeMax))
+	}
+	if opts.geomean && value <= 0 {
+		if opts.skipBad {
+			return false
+		}
+		panic(fmt.Errorf("value %.1f of %q is not positive, geometric mean is undefined", value, key))
+	}
+	if opts.harmonic && value == 0 {
+		if opts.skipBad {
+			return false
+		}
+		panic(fmt.Errorf("value of %q is zero, harmonic mean is undefined", key))
+	}
+	return true
+}
+
+// isSpace reports whether c is ASCII whitespace
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\v' || c == '\f'
+}
+
+// collapseWhitespace replaces runs of whitespace in b with single space.
+// Names rarely need it, so b is only scanned until then and returned as it is.
+// Otherwise collapsed name is written to *scratch, reused by the next call, or to a new slice
+// if scratch is nil. b is never modified: it's a slice of input, which is read again
+// in place by -rejects and by passes like -build-index
+func collapseWhitespace(b []byte, scratch *[]byte) []byte {
+	i := 0
+	for ; i < len(b); i++ {
+		if isSpace(b[i]) && (b[i] != ' ' || i+1 < len(b) && isSpace(b[i+1])) {
+			break
+		}
+	}
+	if i == len(b) {
+		return b
+	}
+
+	var out []byte
+	if scratch != nil {
+		out = (*scratch)[:0]
+	}
+	out = append(out, b[:i]...)
+	for ; i < len(b); i++ {
+		if !isSpace(b[i]) {
+			out = append(out, b[i])
+		} else if i == 0 || !isSpace(b[i-1]) {
+			out = append(out, ' ')
+		}
+	}
+	if scratch != nil {
+		*scratch = out
+	}
+	return out
+}
+
+// unquote strips surrounding double quotes (if any) without copying
+func unquote(b []byte) []byte {
+	if len(b) >= 2 && b[0] == '"' && b[len(b)-1] == '"' {
+		return b[1 : len(b)-1]
+	}
+	return b
+}
+
+// fastFloat parses slice of bytes into float64 without conversion to string
+func fastFloat(b []byte) float64 {
+	var sign float64 = 1
+	var result float64
+	var divisor float64 = 1
+	decimalPointPassed := false
+
+	var i int
+	if b[i] == '-' {
+		sign = -1
+		i++
+	}
+
+	var char byte
+	for ; i < len(b); i++ {
+		char = b[i]
+		if char == '.' {
+			decimalPointPassed = true
+			continue
+		}
+
+		if char < '0' || char > '9' {
+			panic(errors.New("expected [0,9]"))
+		}
+		digit := float64(char - '0')
+
+		if decimalPointPassed {
+			divisor *= 10
+			result += digit / divisor
+		} else {
+			result = result*10 + digit
+		}
+	}
+
+	return result * sign
+
+}
+
+// scaledInt parses integer value of -value-scale units.
+// With scale of 0.1, 0.01, ... value is divided by power of ten, so 123 gives exactly the same float as 12.3 does
+func scaledInt(b []byte) float64 {
+	var (
+		n   int64
+		neg bool
+		i   int
+	)
+	if b[0] == '-' {
+		neg = true
+		i++
+	}
+	if i == len(b) {
+		panic(fmt.Errorf("value %q has no digits", b))
+	}
+	for ; i < len(b); i++ {
+		c := b[i]
+		if c < '0' || c > '9' {
+			panic(fmt.Errorf("value %q is not an integer of -value-scale units", b))
+		}
+		n = n*10 + int64(c-'0')
+	}
+	if neg {
+		n = -n
+	}
+	if opts.scaleDivisor != 0 {
+		return float64(n) / opts.scaleDivisor
+	}
+	return float64(n) * opts.valueScale
+}
+
+// scaleDivisor returns power of ten p if scale is 1/p, 0 otherwise
+func scaleDivisor(scale float64) float64 {
+	for _, p := range pow10 {
+		if math.Abs(scale*p-1) < 1e-12 {
+			return p
+		}
+	}
+	return 0
+}
+
+// pow10 are powers of ten exactly representable in float64
+var pow10 = [...]float64{1e0, 1e1, 1e2, 1e3, 1e4, 1e5, 1e6, 1e7, 1e8, 1e9, 1e10, 1e11,
+	1e12, 1e13, 1e14, 1e15, 1e16, 1e17, 1e18, 1e19, 1e20, 1e21, 1e22}
+
+// exactFloat parses slice of bytes into float64 rounded exactly like strconv.ParseFloat.
+// fastFloat adds digit/divisor per fractional digit, each step rounds and the compiler
+// may fuse result*10+digit into FMA on some architectures (arm64, ppc64, s390x),
+// so its last bit may differ between platforms.
+// Here digits are accumulated as integer and divided once by exact power of ten:
+// both operands are exact, so the single division is correctly rounded (IEEE 754).
+// Longer inputs fall back to strconv
+func exactFloat(b []byte) float64 {
+	var (
+		i        int
+		neg      bool
+		mantissa uint64
+		digits   int
+		frac     = -1 // digits after decimal point, -1 before it
+	)
+	if b[i] == '-' {
+		neg = true
+		i++
+	}
+	for ; i < len(b); i++ {
+		char := b[i]
+		if char == '.' && frac < 0 {
+			frac = 0
+			continue
+		}
+		if char < '0' || char > '9' {
+			panic(errors.New("expected [0,9]"))
+		}
+		mantissa = mantissa*10 + uint64(char-'0')
+		digits++
+		if frac >= 0 {
+			frac++
+		}
+	}
+
+	if digits > 15 || frac >= len(pow10) {
+		// mantissa may exceed 2^53 and be not exact
+		f, err := strconv.ParseFloat(string(b), 64)
+		if err != nil {
+			panic(err)
+		}
+		return f
+	}
+
+	result := float64(mantissa)
+	if frac > 0 {
+		result /= pow10[frac]
+	}
+	if neg {
+		result = -result
+	}
+	return result
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"sync"
+)
+
+// parsedRecord is a record passed from parser to aggregator, key is a slice of input data
+type parsedRecord struct {
+	key   []byte
+	value float64
+	size  int // bytes of record in input, for -byte-stats
+}
+
+// pipelineBatch is the number of records parser sends to aggregator at once,
+// channel operation per record would cost more than parsing it
+const pipelineBatch = 1024
+
+// pipelineScan is mapScan split in two stages connected by channels: opts.parseWorkers goroutines
+// parse chunks into batches of records and opts.aggWorkers goroutines aggregate them.
+// Records of a station always go to the same aggregator (by hash of its name),
+// so returned maps have disjoint stations and reduce only unites them
+func pipelineScan(data []byte, chunks <-chan [2]int) []map[string]Agg {
+	aggregators := opts.aggWorkers
+	inputs := make([]chan []parsedRecord, aggregators)
+	for i := range inputs {
+		inputs[i] = make(chan []parsedRecord, 4)
+	}
+	// batches are reused once aggregated, so there is no allocation per batch.
+	// At most there are batches queued to every aggregator plus batches being filled by every parser
+	free := make(chan []parsedRecord, aggregators*(cap(inputs[0])+opts.parseWorkers))
+
+	var parsers sync.WaitGroup
+	for p := 0; p < opts.parseWorkers; p++ {
+		parsers.Add(1)
+		go func() {
+			defer parsers.Done()
+			batches := make([][]parsedRecord, aggregators)
+			filter := make(stationFilter, stationsHint())
+			send := func(a int) {
+				inputs[a] <- batches[a]
+				select {
+				case batches[a] = <-free:
+				default:
+					batches[a] = make([]parsedRecord, 0, pipelineBatch)
+				}
+			}
+			for a := range batches {
+				batches[a] = make([]parsedRecord, 0, pipelineBatch)
+			}
+			for c := range chunks {
+				if stopped() {
+					break
+				}
+				parseChunk(data, c[0], c[1], filter, func(r parsedRecord) {
+					a := 0
+					if aggregators > 1 {
+						a = int(stationHash(r.key) % uint32(aggregators))
+					}
+					batches[a] = append(batches[a], r)
+					if len(batches[a]) == pipelineBatch {
+						send(a)
+					}
+				})
+			}
+			for a, batch := range batches {
+				if len(batch) > 0 {
+					inputs[a] <- batch
+				}
+			}
+		}()
+	}
+	go func() {
+		parsers.Wait()
+		for _, in := range inputs {
+			close(in)
+		}
+	}()
+
+	results := make([]map[string]Agg, aggregators)
+	var wg sync.WaitGroup
+	for a := 0; a < aggregators; a++ {
+		a := a
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m := make(map[string]*Agg, stationsHint())
+			for batch := range inputs[a] {
+				for _, r := range batch {
+					agg := m[string(r.key)]
+					if agg == nil {
+						v := newAgg(r.value)
+						agg = &v
+						m[string(r.key)] = agg
+					} else {
+						agg.Add(r.value)
+					}
+					if opts.byteStats {
+						agg.bytes += r.size
+					}
+				}
+				select {
+				case free <- batch[:0]:
+				default:
+				}
+			}
+			results[a] = derefMap(m)
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// parseChunk parses records of chunk [i, end) like scan does and passes the ones to aggregate to emit.
+// Keys are kept in batches after the next record is parsed, so ones changed by -collapse-whitespace
+// are allocated (nil scratch) rather than overwritten in scratch
+func parseChunk(data []byte, i int, end int, filter stationFilter, emit func(parsedRecord)) {
+	var (
+		key      []byte
+		value    float64
+		start    int
+		rejected []byte
+	)
+	for i < end {
+		if opts.sample {
+			var skip bool
+			if i, skip = skipUnsampled(data, i); skip {
+				continue
+			}
+		}
+		start = i
+		key, value, i = parseRecord(data, i, nil)
+		if opts.filter && !filter.keep(key) {
+			continue
+		}
+		if !checkValue(key, value) {
+			if rejects != nil {
+				rejected = appendReject(rejected, data, start, i)
+			}
+			continue
+		}
+		emit(parsedRecord{key: key, value: value, size: i - start})
+	}
+	if rejects != nil {
+		rejects.write(rejected)
+	}
+}
+
+// stationHash is FNV-1a of station name, it picks aggregator of the station (and shard of sharedTable)
+func stationHash(key []byte) uint32 {
+	h := uint32(2166136261)
+	for _, c := range key {
+		h ^= uint32(c)
+		h *= 16777619
+	}
+	return h
+}
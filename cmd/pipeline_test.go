@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestPipeline(t *testing.T) {
+	data := string(genMeasurements(30000, 300))
+	// names with runs of spaces go through -collapse-whitespace, whose keys must outlive the batch
+	data += "New   York;1.0\nNew York;2.0\nNew\t York;3.0\n"
+	for _, args := range [][]string{
+		{"-chunk-bytes", "4096"},
+		{"-chunk-bytes", "4096", "-collapse-whitespace", "-byte-stats", "-format", "csv"},
+		{"-chunk-bytes", "0", "-include", "^Station[12]"},
+	} {
+		setFlags(t, args...)
+		want := formatResults(aggregate(data, 3), opts.format)
+		for _, ratio := range [][2]int{{1, 1}, {2, 1}, {1, 2}, {4, 2}, {2, 4}, {3, 3}} {
+			setFlags(t, append(args, "-parse-workers", fmt.Sprint(ratio[0]), "-aggregate-workers", fmt.Sprint(ratio[1]))...)
+			results := mapScan(terminateLastLine([]byte(data)), scan, ratio[0])
+			if len(results) != ratio[1] {
+				t.Fatalf("%v: got %d maps, want one per aggregator", ratio, len(results))
+			}
+			// every station is aggregated by one aggregator only
+			seen := make(map[string]bool)
+			for _, m := range results {
+				for key := range m {
+					if seen[key] {
+						t.Errorf("%v: %s is in several aggregators", ratio, key)
+					}
+					seen[key] = true
+				}
+			}
+			if got := formatResults(prepareResults(reduce(results...)), opts.format); got != want {
+				t.Errorf("%v %v: got\n%.300s\nwant\n%.300s", args, ratio, got, want)
+			}
+		}
+	}
+}
+
+func TestPipelineDenseKeys(t *testing.T) {
+	// aggregators keep stations in maps only, -dense-keys would be silently ignored there
+	out, code := runMain(t, t.TempDir(), "-parse-workers", "2", "-dense-keys", "100")
+	if code != 2 || !strings.Contains(out, "-dense-keys") {
+		t.Errorf("exit %d: %s", code, out)
+	}
+}
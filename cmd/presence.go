@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"strings"
+)
+
+// maxShards is the number of input shards -presence can tell apart, one bit of Agg.presence each
+const maxShards = 64
+
+// shards are names of input shards (files of -input-glob, members of .tar.gz) in order of processing,
+// index of shard is its bit in Agg.presence
+var shards []string
+
+// addShard registers next shard and returns its presence bit
+func addShard(name string) uint64 {
+	if len(shards) == maxShards {
+		log.Fatalf("-presence supports at most %d input shards", maxShards)
+	}
+	shards = append(shards, name)
+	return 1 << (len(shards) - 1)
+}
+
+// markShard sets bit of shard in presence of all stations of results
+func markShard(bit uint64, results ...map[string]Agg) {
+	for _, m := range results {
+		for key, v := range m {
+			v.presence |= bit
+			m[key] = v
+		}
+	}
+}
+
+// formatPresence writes presence as a character per shard in order of processing:
+// 1 if station has records in the shard, 0 if not, e.g. 101 for a station missing in the second shard
+func formatPresence(presence uint64) string {
+	var sb strings.Builder
+	for i := range shards {
+		sb.WriteByte('0' + byte(presence>>i&1))
+	}
+	return sb.String()
+}
+
+// printShards writes index and name of every shard, the legend of presence field
+func printShards(w io.Writer) {
+	for i, name := range shards {
+		fmt.Fprintf(w, "shard %d: %s\n", i, name)
+	}
+}
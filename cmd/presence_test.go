@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// resetShards empties shards of -presence and restores them after test
+func resetShards(t *testing.T) {
+	saved := shards
+	shards = nil
+	t.Cleanup(func() { shards = saved })
+}
+
+func TestPresence(t *testing.T) {
+	dir := t.TempDir()
+	var paths []string
+	for _, shard := range [][2]string{
+		{"a.txt", "Oslo;1.0\nBergen;2.0\n"},
+		{"b.txt", "Oslo;3.0\nOslo;-1.0\n"},
+		{"c.txt", "Tromsø;0.5\nBergen;4.0\nOslo;2.0\n"},
+	} {
+		path := filepath.Join(dir, shard[0])
+		if err := os.WriteFile(path, []byte(shard[1]), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+	setFlags(t, "-presence", "-input-glob", filepath.Join(dir, "*.txt"), "-format", "csv")
+	resetShards(t)
+	results := scanFiles(paths, 2)
+
+	want := map[string]string{"Oslo": "111", "Bergen": "101", "Tromsø": "001"}
+	for key, presence := range want {
+		if got := formatPresence(results[key].presence); got != presence {
+			t.Errorf("%s: got presence %s, want %s", key, got, presence)
+		}
+	}
+	if got, want := formatResults(results, "csv"),
+		"station,min,mean,max,presence\nBergen,2.0,3.0,4.0,101\nOslo,-1.0,1.3,3.0,111\nTromsø,0.5,0.5,0.5,001\n"; got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+	var legend bytes.Buffer
+	printShards(&legend)
+	if got, want := legend.String(), "shard 0: "+paths[0]+"\nshard 1: "+paths[1]+"\nshard 2: "+paths[2]+"\n"; got != want {
+		t.Errorf("got legend\n%s\nwant\n%s", got, want)
+	}
+
+	// presence is kept by partial aggregates of checkpoints
+	path := filepath.Join(dir, "run.checkpoint")
+	saveCheckpoint(path, results, 10, 100)
+	loaded, _ := loadCheckpoint(path, 100)
+	for key, presence := range want {
+		if got := formatPresence(loaded[key].presence); got != presence {
+			t.Errorf("%s: got presence %s from checkpoint, want %s", key, got, presence)
+		}
+	}
+}
+
+func TestPresenceTarGz(t *testing.T) {
+	setFlags(t, "-presence", "-input", "in.tar.gz")
+	resetShards(t)
+	archive := tarGz(t, [2]string{"2024/01.txt", "Oslo;1.0\n"}, [2]string{"2024/02.txt", "Oslo;2.0\nBergen;3.0\n"})
+	results := scanTarGz(bytes.NewReader(archive), 1)
+	if got := formatPresence(results["Oslo"].presence) + " " + formatPresence(results["Bergen"].presence); got != "11 01" {
+		t.Errorf("got presence %s", got)
+	}
+	if strings.Join(shards, ",") != "2024/01.txt,2024/02.txt" {
+		t.Errorf("got shards %v", shards)
+	}
+}
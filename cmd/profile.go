@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/google/pprof/profile"
+)
+
+// functionTime is CPU time spent in function itself (flat) and together with its callees (cum)
+type functionTime struct {
+	name string
+	flat int64
+	cum  int64
+}
+
+// printProfileSummary prints top n functions by flat CPU time of profile at path,
+// like `go tool pprof -top` does, but without extra tooling
+func printProfileSummary(path string, n int, w io.Writer) {
+	f, err := os.Open(path)
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+
+	p, err := profile.Parse(f)
+	if err != nil {
+		panic(err)
+	}
+
+	top, total := topFunctions(p)
+	if len(top) > n {
+		top = top[:n]
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "flat\tflat%\tcum\tcum%\t")
+	for _, ft := range top {
+		fmt.Fprintf(tw, "%s\t%.1f%%\t%s\t%.1f%%\t %s\n",
+			formatProfileValue(p, ft.flat), percent(ft.flat, total),
+			formatProfileValue(p, ft.cum), percent(ft.cum, total),
+			ft.name,
+		)
+	}
+	tw.Flush()
+}
+
+// topFunctions aggregates samples of the last sample type (cpu nanoseconds for CPU profile)
+// by function, sorted by flat time
+func topFunctions(p *profile.Profile) ([]functionTime, int64) {
+	valueIdx := len(p.SampleType) - 1
+	byName := make(map[string]*functionTime)
+	get := func(name string) *functionTime {
+		ft := byName[name]
+		if ft == nil {
+			ft = &functionTime{name: name}
+			byName[name] = ft
+		}
+		return ft
+	}
+
+	var total int64
+	for _, s := range p.Sample {
+		value := s.Value[valueIdx]
+		total += value
+
+		seen := make(map[string]bool) // recursive functions are counted once in cum
+		for i, loc := range s.Location {
+			for j, line := range loc.Line {
+				name := line.Function.Name
+				if i == 0 && j == 0 {
+					get(name).flat += value // Line[0] of leaf location is the innermost function
+				}
+				if !seen[name] {
+					seen[name] = true
+					get(name).cum += value
+				}
+			}
+		}
+	}
+
+	out := make([]functionTime, 0, len(byName))
+	for _, ft := range byName {
+		out = append(out, *ft)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].flat != out[j].flat {
+			return out[i].flat > out[j].flat
+		}
+		return out[i].name < out[j].name
+	})
+	return out, total
+}
+
+func formatProfileValue(p *profile.Profile, value int64) string {
+	if p.SampleType[len(p.SampleType)-1].Unit == "nanoseconds" {
+		return fmt.Sprintf("%.2fs", float64(value)/1e9)
+	}
+	return fmt.Sprint(value)
+}
+
+func percent(value, total int64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(value) * 100 / float64(total)
+}
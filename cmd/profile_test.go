@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/pprof/profile"
+)
+
+// writeProfile writes CPU profile with samples of call stacks (leaf first) taking seconds each
+func writeProfile(t *testing.T, stacks [][]string, seconds []int64) string {
+	t.Helper()
+	p := &profile.Profile{
+		SampleType: []*profile.ValueType{{Type: "samples", Unit: "count"}, {Type: "cpu", Unit: "nanoseconds"}},
+		PeriodType: &profile.ValueType{Type: "cpu", Unit: "nanoseconds"},
+		Period:     10000000,
+	}
+	functions := make(map[string]*profile.Location)
+	for i, stack := range stacks {
+		var locations []*profile.Location
+		for _, name := range stack {
+			loc := functions[name]
+			if loc == nil {
+				id := uint64(len(functions) + 1)
+				fn := &profile.Function{ID: id, Name: name}
+				loc = &profile.Location{ID: id, Line: []profile.Line{{Function: fn}}}
+				functions[name] = loc
+				p.Function = append(p.Function, fn)
+				p.Location = append(p.Location, loc)
+			}
+			locations = append(locations, loc)
+		}
+		p.Sample = append(p.Sample, &profile.Sample{Location: locations, Value: []int64{seconds[i] * 100, seconds[i] * 1e9}})
+	}
+
+	path := filepath.Join(t.TempDir(), "cpu.prof")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := p.Write(f); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestProfileSummary(t *testing.T) {
+	path := writeProfile(t,
+		[][]string{{"main.parse", "main.scan"}, {"main.scan"}, {"main.add", "main.scan"}, {"main.scan", "main.scan"}},
+		[]int64{3, 1, 2, 2},
+	)
+	var b bytes.Buffer
+	printProfileSummary(path, 3, &b)
+	// scan is recursive in the last sample, it's counted once in cum
+	want := "" +
+		"   flat  flat%    cum    cum%\n" +
+		"  3.00s  37.5%  3.00s   37.5% main.parse\n" +
+		"  3.00s  37.5%  8.00s  100.0% main.scan\n" +
+		"  2.00s  25.0%  2.00s   25.0% main.add\n"
+	if b.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", b.String(), want)
+	}
+}
+
+func TestLabelsFlag(t *testing.T) {
+	var l labelsFlag
+	for _, s := range []string{"exp=chunks", "run=2", "note=a=b", "empty="} {
+		if err := l.Set(s); err != nil {
+			t.Errorf("%q: %s", s, err)
+		}
+	}
+	for _, s := range []string{"exp", "=value", ""} {
+		if err := l.Set(s); err == nil {
+			t.Errorf("%q: no error", s)
+		}
+	}
+	if got, want := l.String(), "exp=chunks,run=2,note=a=b,empty="; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestProfileLabels(t *testing.T) {
+	if testing.Short() {
+		t.Skip("needs a run long enough for CPU samples")
+	}
+	dir := t.TempDir()
+	// ~64MB takes a few hundred milliseconds, tens of samples at 100Hz
+	if err := os.WriteFile(filepath.Join(dir, "in.txt"), genMeasurements(4<<20, 400), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	out, code := runMain(t, dir, "-input", "in.txt", "-output", "result.txt",
+		"-profile-label", "exp=chunks", "-profile-label", "run=2")
+	if code != 0 {
+		t.Fatalf("exit %d: %s", code, out)
+	}
+
+	f, err := os.Open(filepath.Join(dir, cpuProfilePath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	p, err := profile.Parse(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	labeled := 0
+	for _, s := range p.Sample {
+		if len(s.Label["exp"]) == 1 && s.Label["exp"][0] == "chunks" &&
+			len(s.Label["run"]) == 1 && s.Label["run"][0] == "2" {
+			labeled++
+		}
+	}
+	if labeled == 0 {
+		t.Errorf("none of %d samples has exp=chunks, run=2 labels", len(p.Sample))
+	}
+}
@@ -0,0 +1,70 @@
+//go:build protobuf
+
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"math"
+)
+
+func init() {
+	formats["protobuf"] = printProtobuf
+	formatExts[".pb"] = "protobuf"
+	fixedFields["protobuf"] = []field{
+		{"name", "string", baseFields[0].value},
+		baseFields[1],
+		baseFields[2],
+		baseFields[3],
+		{"count", "int", func(_ string, v Agg) any { return v.count }},
+	}
+}
+
+// protobuf wire types
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+)
+
+// printProtobuf writes length-delimited stream of Station messages of station.proto.
+// Encoding is handwritten (the message is small and fixed), so format has no dependencies;
+// zero name and count are omitted like proto3 does, min/mean/max are set unless station is absent in data
+func printProtobuf(data map[string]Agg, keys []string, w io.Writer) {
+	bw := bufio.NewWriter(w)
+	var msg, size []byte
+	for _, key := range keys {
+		v, ok := data[key]
+		msg = msg[:0]
+		if key != "" {
+			msg = appendTag(msg, 1, wireBytes)
+			msg = binary.AppendUvarint(msg, uint64(len(key)))
+			msg = append(msg, key...)
+		}
+		if ok {
+			msg = appendDouble(msg, 2, round(v.min))
+			msg = appendDouble(msg, 3, round(v.mean()))
+			msg = appendDouble(msg, 4, round(v.max))
+			if v.count != 0 {
+				msg = appendTag(msg, 5, wireVarint)
+				msg = binary.AppendUvarint(msg, uint64(v.count))
+			}
+		}
+		size = binary.AppendUvarint(size[:0], uint64(len(msg)))
+		bw.Write(size)
+		bw.Write(msg)
+	}
+	if err := bw.Flush(); err != nil {
+		panic(err)
+	}
+}
+
+func appendTag(b []byte, field int, wireType int) []byte {
+	return binary.AppendUvarint(b, uint64(field<<3|wireType))
+}
+
+func appendDouble(b []byte, field int, value float64) []byte {
+	b = appendTag(b, field, wireFixed64)
+	return binary.LittleEndian.AppendUint64(b, math.Float64bits(value))
+}
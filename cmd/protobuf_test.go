@@ -0,0 +1,95 @@
+//go:build protobuf
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"testing"
+)
+
+// station is decoded Station message of station.proto
+type station struct {
+	name           string
+	min, mean, max float64
+	count          uint64
+	set            int // number of fields present
+}
+
+// decodeStations reads length-delimited Station messages, independently of printProtobuf
+func decodeStations(b []byte) ([]station, error) {
+	var out []station
+	for len(b) > 0 {
+		size, n := binary.Uvarint(b)
+		if n <= 0 || uint64(len(b)-n) < size {
+			return nil, fmt.Errorf("bad message size")
+		}
+		msg := b[n : n+int(size)]
+		b = b[n+int(size):]
+
+		var s station
+		for len(msg) > 0 {
+			tag, n := binary.Uvarint(msg)
+			if n <= 0 {
+				return nil, fmt.Errorf("bad tag")
+			}
+			msg = msg[n:]
+			field, wireType := tag>>3, tag&7
+			switch wireType {
+			case wireVarint:
+				v, n := binary.Uvarint(msg)
+				if n <= 0 || field != 5 {
+					return nil, fmt.Errorf("bad varint field %d", field)
+				}
+				s.count, msg = v, msg[n:]
+			case wireFixed64:
+				if len(msg) < 8 || field < 2 || field > 4 {
+					return nil, fmt.Errorf("bad fixed64 field %d", field)
+				}
+				v := math.Float64frombits(binary.LittleEndian.Uint64(msg))
+				switch field {
+				case 2:
+					s.min = v
+				case 3:
+					s.mean = v
+				case 4:
+					s.max = v
+				}
+				msg = msg[8:]
+			case wireBytes:
+				l, n := binary.Uvarint(msg)
+				if n <= 0 || uint64(len(msg)-n) < l || field != 1 {
+					return nil, fmt.Errorf("bad bytes field %d", field)
+				}
+				s.name, msg = string(msg[n:n+int(l)]), msg[n+int(l):]
+			default:
+				return nil, fmt.Errorf("unexpected wire type %d", wireType)
+			}
+			s.set++
+		}
+		out = append(out, s)
+	}
+	return out, nil
+}
+
+func TestProtobufOutput(t *testing.T) {
+	setFlags(t, "-format", "protobuf", "-keys-file", writeFile(t, "keys.txt", "Hamburg\nNowhere\nOslo\n"))
+	var b bytes.Buffer
+	results := aggregate("Oslo;-3.5\nHamburg;12.0\nHamburg;-1.0\n", 1)
+	printProtobuf(results, sortedKeys(results), &b)
+
+	got, err := decodeStations(b.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []station{
+		{name: "Hamburg", min: -1, mean: 5.5, max: 12, count: 2, set: 5},
+		{name: "Nowhere", set: 1}, // absent station has name only
+		{name: "Oslo", min: -3.5, mean: -3.5, max: -3.5, count: 1, set: 5},
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// recentPath is a file -recent values are written to
+const recentPath = "recent.txt"
+
+// recentValues is a ring buffer of the last opts.recent values of station
+type recentValues struct {
+	values []float64
+	next   int // position of the oldest value once buffer is full
+}
+
+func (r *recentValues) add(value float64) {
+	if len(r.values) < opts.recent {
+		r.values = append(r.values, value)
+		return
+	}
+	r.values[r.next] = value
+	r.next = (r.next + 1) % len(r.values)
+}
+
+// ordered returns values from the oldest to the latest, r may be nil
+func (r *recentValues) ordered() []float64 {
+	if r == nil {
+		return nil
+	}
+	return append(r.values[r.next:len(r.values):len(r.values)], r.values[:r.next]...)
+}
+
+// merge keeps the last values of r followed by other, other has to follow r in input order.
+// Result is a new buffer, so aggregates sharing r (copies of Agg) are not changed
+func (r *recentValues) merge(other *recentValues) *recentValues {
+	values := append(r.ordered(), other.ordered()...)
+	values = values[max(len(values)-opts.recent, 0):]
+	return &recentValues{values: append([]float64(nil), values...)}
+}
+
+// writeRecent writes `station: v1 v2 ...` line per station in output order, the latest value last.
+// -global and -rollup entries are left out
+func writeRecent(data map[string]Agg, path string) {
+	writeFileAtomic(path, opts.noClobber, func(w io.Writer) {
+		bw := bufio.NewWriter(w)
+		for _, key := range sortedKeys(data) {
+			v, ok := data[key]
+			if !ok || v.recent == nil || key == globalKey && opts.global {
+				continue // order of values across stations is lost by merge
+			}
+			bw.WriteString(key)
+			bw.WriteByte(':')
+			for _, value := range v.recent.ordered() {
+				fmt.Fprintf(bw, " %.1f", value)
+			}
+			bw.WriteByte('\n')
+		}
+		if err := bw.Flush(); err != nil {
+			panic(err)
+		}
+	})
+}
+
+// formatRecent writes recent values from the oldest separated by comma, for checkpoint
+func formatRecent(r *recentValues) string {
+	var sb strings.Builder
+	for i, value := range r.ordered() {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(strconv.FormatFloat(value, 'g', -1, 64))
+	}
+	return sb.String()
+}
+
+// parseRecent reads values written by formatRecent
+func parseRecent(s string) (*recentValues, error) {
+	if s == "" {
+		return nil, nil
+	}
+	r := &recentValues{}
+	for _, value := range strings.Split(s, ",") {
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return r, err
+		}
+		r.values = append(r.values, v)
+	}
+	if len(r.values) > opts.recent { // checkpoint of run with greater -recent
+		r.values = r.values[len(r.values)-opts.recent:]
+	}
+	return r, nil
+}
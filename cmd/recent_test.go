@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRecent(t *testing.T) {
+	setFlags(t, "-recent", "3")
+	var data strings.Builder
+	for i := 1; i <= 10; i++ {
+		fmt.Fprintf(&data, "A;%d.0\n", i)
+		if i%4 == 0 {
+			fmt.Fprintf(&data, "B;%d.5\n", i)
+		}
+	}
+	results := aggregate(data.String(), 1)
+	for key, want := range map[string]string{"A": "[8 9 10]", "B": "[4.5 8.5]"} {
+		if got := fmt.Sprint(results[key].recent.ordered()); got != want {
+			t.Errorf("%s: got %s, want %s", key, got, want)
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), recentPath)
+	writeRecent(results, path)
+	if got, _ := os.ReadFile(path); string(got) != "A: 8.0 9.0 10.0\nB: 4.5 8.5\n" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestRecentMerge(t *testing.T) {
+	setFlags(t, "-recent", "4")
+	for split := 0; split <= 10; split++ {
+		var a, b recentValues
+		for i := 1; i <= 10; i++ {
+			if i <= split {
+				a.add(float64(i))
+			} else {
+				b.add(float64(i))
+			}
+		}
+		aOrdered := fmt.Sprint(a.ordered())
+		// consecutive parts keep the last values of both
+		if got := fmt.Sprint(a.merge(&b).ordered()); got != "[7 8 9 10]" {
+			t.Errorf("split at %d: got %s", split, got)
+		}
+		if fmt.Sprint(a.ordered()) != aOrdered {
+			t.Errorf("split at %d: merge changed its receiver", split)
+		}
+	}
+
+	r, err := parseRecent(formatRecent(&recentValues{values: []float64{3, 4, 1, 2}, next: 2}))
+	if err != nil || fmt.Sprint(r.ordered()) != "[1 2 3 4]" {
+		t.Errorf("got %v, %v after checkpoint round trip", r.ordered(), err)
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"sync"
+)
+
+// rejectsFile collects raw records skipped by checkValue, from all workers
+type rejectsFile struct {
+	mu sync.Mutex
+	f  *os.File
+	w  *bufio.Writer
+}
+
+// rejects is nil unless -rejects is set
+var rejects *rejectsFile
+
+func openRejects(path string) *rejectsFile {
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if opts.noClobber {
+		flags |= os.O_EXCL
+	}
+	f, err := os.OpenFile(path, flags, 0o644)
+	if err != nil {
+		panic(err)
+	}
+	return &rejectsFile{f: f, w: bufio.NewWriter(f)}
+}
+
+// write appends records (newline terminated) of one scan, so records of a chunk stay together
+func (r *rejectsFile) write(records []byte) {
+	if len(records) == 0 {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, err := r.w.Write(records); err != nil {
+		panic(err)
+	}
+}
+
+func (r *rejectsFile) close() {
+	if err := r.w.Flush(); err != nil {
+		panic(err)
+	}
+	if err := r.f.Close(); err != nil {
+		panic(err)
+	}
+}
+
+// appendReject appends record data[start:next] to buf as a line,
+// record of a multi-record line (see -record-inline-sep) ends with separator instead of newline
+func appendReject(buf []byte, data []byte, start, next int) []byte {
+	return append(append(buf, data[start:next-1]...), '\n')
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// notifyRecompute installs SIGHUP handler for -recompute-on-signal. It's done before the first run,
+// so signal sent during it queues one more run instead of killing process
+func notifyRecompute() <-chan os.Signal {
+	// buffer of one coalesces signals which arrive during a run, the next run reads the latest input anyway
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	return hup
+}
+
+// recomputeOnSignal re-runs aggregation over the whole input on every SIGHUP, until process is killed.
+// This process is never restarted: it keeps its pid and the profile of the first run, while every
+// run is a child process with the same flags (see recomputeArgs). So a run starts from clean state,
+// and if it fails (e.g. file is caught in the middle of a write or panics on a bad record)
+// outputs are left as they were, since they are replaced atomically, and this process keeps waiting
+// for the next signal. Panic of a worker goroutine couldn't be recovered in process
+func recomputeOnSignal(hup <-chan os.Signal) {
+	self, err := os.Executable()
+	if err != nil {
+		panic(err)
+	}
+	args := recomputeArgs(os.Args[1:], flag.NArg())
+	fmt.Fprintf(diag, "waiting for SIGHUP to recompute (pid %d)\n", os.Getpid())
+	for range hup {
+		t0 := time.Now()
+		cmd := exec.Command(self, args...)
+		cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+		if err := cmd.Run(); err != nil {
+			fmt.Fprintf(os.Stderr, "recompute failed, outputs are not changed: %v\n", err)
+			continue
+		}
+		fmt.Fprintf(diag, "recomputed in %s\n", time.Since(t0))
+	}
+}
+
+// recomputeArgs returns command line arguments of recompute run: without -recompute-on-signal
+// and -profile-summary, which are for this process, and with -no-profile, so profile of the first run
+// isn't overwritten. Last positional arguments are kept as they are, even if they look like flags
+func recomputeArgs(args []string, positional int) []string {
+	out := []string{"-no-profile"}
+	flags := len(args) - positional
+	for i := 0; i < flags; i++ {
+		arg := args[i]
+		name, _, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if strings.HasPrefix(arg, "-") {
+			switch name {
+			case "recompute-on-signal":
+				continue
+			case "profile-summary":
+				if !hasValue {
+					i++ // value is the next argument
+				}
+				continue
+			}
+		}
+		out = append(out, arg)
+	}
+	return append(out, args[flags:]...)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestRecomputeArgs(t *testing.T) {
+	for _, tt := range []struct {
+		args       []string
+		positional int
+		want       string
+	}{
+		{[]string{"-recompute-on-signal", "-input", "a.txt"}, 0, "-no-profile -input a.txt"},
+		{[]string{"--recompute-on-signal=true", "-profile-summary", "5", "-format", "json"}, 0, "-no-profile -format json"},
+		{[]string{"-profile-summary=5", "-workers", "2"}, 0, "-no-profile -workers 2"},
+		// positional inputs are kept even if they look like flags
+		{[]string{"-recompute-on-signal", "a.txt", "-recompute-on-signal"}, 2, "-no-profile a.txt -recompute-on-signal"},
+	} {
+		if got := strings.Join(recomputeArgs(tt.args, tt.positional), " "); got != tt.want {
+			t.Errorf("%v: got %s, want %s", tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestRecomputeOnSignal(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no SIGHUP")
+	}
+	input := writeFile(t, "in.txt", "Oslo;-3.5\n")
+	dir := filepath.Dir(input)
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(exe, "-input", "in.txt", "-output", "out.txt", "-no-profile", "-recompute-on-signal")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "BRC_TEST_MAIN=1")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd.Stderr = cmd.Stdout
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+	sc := bufio.NewScanner(stdout)
+	// waitLine reads output of process up to line starting with prefix
+	waitLine := func(prefix string) {
+		t.Helper()
+		for sc.Scan() {
+			if strings.HasPrefix(sc.Text(), prefix) {
+				return
+			}
+		}
+		t.Fatalf("process exited before %q", prefix)
+	}
+	checkOutput := func(want string) {
+		t.Helper()
+		if got, _ := os.ReadFile(filepath.Join(dir, "out.txt")); string(got) != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	}
+
+	waitLine("waiting for SIGHUP")
+	checkOutput("{Oslo=-3.5/-3.5/-3.5}")
+	// input grows, output is rewritten on every signal by the same process
+	for _, tt := range []struct{ data, want string }{
+		{"Oslo;-3.5\nOslo;1.5\n", "{Oslo=-3.5/-1.0/1.5}"},
+		{"Oslo;-3.5\nOslo;1.5\nBergen;7.0\n", "{Bergen=7.0/7.0/7.0, Oslo=-3.5/-1.0/1.5}"},
+	} {
+		if err := os.WriteFile(input, []byte(tt.data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := cmd.Process.Signal(syscall.SIGHUP); err != nil {
+			t.Fatal(err)
+		}
+		waitLine("recomputed in")
+		checkOutput(tt.want)
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// prepareResults decodes keys and adds global aggregate, as requested by flags
+func prepareResults(results map[string]Agg) map[string]Agg {
+	if opts.decoder != nil {
+		results = decodeKeys(results, opts.decoder)
+	}
+	if opts.sample {
+		scaleSample(results)
+	}
+	// global is of stations only, before rollup groups are added
+	var global Agg
+	addGlobal := opts.global && len(results) > 0
+	if addGlobal {
+		global = globalAgg(results)
+	}
+	if opts.rollupSep != "" {
+		addRollups(results, opts.rollupSep)
+	}
+	if opts.hashKeys {
+		results = hashKeys(results)
+	}
+
+	if addGlobal {
+		results[globalKey] = global
+	}
+	return results
+}
+
+func writeResultsToFile(results map[string]Agg) {
+	if opts.parallelWrite > 1 {
+		writeShards(results, opts.outputs[0], opts.parallelWrite)
+		return
+	}
+	keys := sortedKeys(results)
+	for _, out := range opts.outputs {
+		writeResults(results, keys, out.path, out.format)
+	}
+}
+
+// writeResults writes stations of keys to path in format
+func writeResults(results map[string]Agg, keys []string, path, format string) {
+	if write, ok := fileFormats[format]; ok {
+		replaceFileAtomic(path, opts.noClobber, func(tmpPath string) {
+			write(results, keys, tmpPath)
+		})
+		return
+	}
+	writeFileAtomic(path, opts.noClobber, func(w io.Writer) {
+		formats[format](results, keys, w)
+	})
+}
+
+// noClobberPaths returns files run is going to write, which must not exist with -no-clobber.
+// They are created exclusively anyway, this is to fail before input is processed.
+// -dump-worker-maps files aren't known in advance, they are only checked when written
+func noClobberPaths() []string {
+	var paths []string
+	for _, out := range opts.outputs {
+		if opts.parallelWrite > 1 {
+			// there are fewer parts if there are fewer stations, but they can't be counted yet
+			parts, manifest := shardPaths(out.path, opts.parallelWrite)
+			paths = append(append(paths, parts...), manifest)
+		} else {
+			paths = append(paths, out.path)
+		}
+	}
+	if opts.snapshotEvery > 0 {
+		// previous snapshot of the run is renamed to .1
+		path := snapshotPath(opts.outputs[0].path)
+		paths = append(paths, path, path+".1")
+	}
+	if opts.recent > 0 {
+		paths = append(paths, recentPath)
+	}
+	for _, path := range []string{opts.dumpMap, opts.rejects, opts.buildIndex} {
+		if path != "" {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// writeFileAtomic writes file via write func, see replaceFileAtomic
+func writeFileAtomic(path string, noClobber bool, write func(w io.Writer)) {
+	replaceFileAtomic(path, noClobber, func(tmpPath string) {
+		f, err := os.OpenFile(tmpPath, os.O_WRONLY, 0)
+		if err != nil {
+			panic(err)
+		}
+		write(f)
+		if err := f.Close(); err != nil {
+			panic(err)
+		}
+	})
+}
+
+// replaceFileAtomic creates empty temporary file in the same directory as path,
+// lets fill func write it by name and moves it into place once it's fully written,
+// so partial file is never left behind.
+// With noClobber it fails if path already exists
+func replaceFileAtomic(path string, noClobber bool, fill func(tmpPath string)) {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		panic(err)
+	}
+	tmpPath := f.Name()
+	defer os.Remove(tmpPath) // no-op once file is moved
+
+	// CreateTemp makes file readable only by owner
+	if err := f.Chmod(0o644); err != nil {
+		panic(err)
+	}
+	if err := f.Close(); err != nil {
+		panic(err)
+	}
+
+	fill(tmpPath)
+
+	if noClobber {
+		// link fails if path exists, unlike rename which replaces it
+		err = os.Link(tmpPath, path)
+	} else {
+		err = os.Rename(tmpPath, path)
+	}
+	if err != nil {
+		panic(err)
+	}
+}
+
+func printResults(data map[string]Agg, keys []string, w io.Writer) {
+	if opts.fastOutput {
+		appendResults(data, keys, w)
+		return
+	}
+	w.Write([]byte{'{'})
+
+	var res string
+	for i, key := range keys {
+		if i > 0 {
+			w.Write([]byte(", "))
+		}
+		v, ok := data[key]
+		if !ok {
+			res = key + "=" + notAvailable // station from -keys-file which is absent in data
+		} else {
+			res = fmt.Sprintf("%s=%.1f/%.1f/%.1f", key, round(v.min), round(v.sum/float64(v.count)), round(v.max))
+		}
+		w.Write([]byte(res))
+	}
+
+	w.Write([]byte{'}'})
+}
+
+// appendResults writes the same bytes as printResults, but appends stations into one buffer,
+// which is written out when it grows over 64KB. strconv.AppendFloat with 'f' and precision 1
+// is what fmt does for %.1f, including -0.0, NaN and +Inf
+func appendResults(data map[string]Agg, keys []string, w io.Writer) {
+	const flushSize = 64 << 10
+	buf := make([]byte, 0, flushSize+256)
+	buf = append(buf, '{')
+	for i, key := range keys {
+		if i > 0 {
+			buf = append(buf, ", "...)
+		}
+		buf = append(buf, key...)
+		buf = append(buf, '=')
+		v, ok := data[key]
+		if !ok {
+			buf = append(buf, notAvailable...) // station from -keys-file which is absent in data
+		} else {
+			buf = strconv.AppendFloat(buf, round(v.min), 'f', 1, 64)
+			buf = append(buf, '/')
+			buf = strconv.AppendFloat(buf, round(v.sum/float64(v.count)), 'f', 1, 64)
+			buf = append(buf, '/')
+			buf = strconv.AppendFloat(buf, round(v.max), 'f', 1, 64)
+		}
+		if len(buf) >= flushSize {
+			w.Write(buf)
+			buf = buf[:0]
+		}
+	}
+	buf = append(buf, '}')
+	w.Write(buf)
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// parseRollup parses -rollup spec, the only kind is prefix:<separator>
+func parseRollup(spec string) (sep string, err error) {
+	kind, sep, ok := strings.Cut(spec, ":")
+	if !ok || kind != "prefix" {
+		return "", fmt.Errorf("expected prefix:<separator>, e.g. prefix:/")
+	}
+	if sep == "" {
+		return "", fmt.Errorf("empty separator")
+	}
+	return sep, nil
+}
+
+// addRollups adds aggregate of every group of stations sharing name prefix up to the first sep,
+// under `<prefix><sep>` key (e.g. "France/" for "France/Paris" and "France/Lyon"), which sorts
+// right before its stations. Groups are merged from station aggregates of the same scan, so they are exact
+// and cost nothing per record. Stations without sep belong to no group.
+// Groups have no -recent values: stations are merged in no particular order, so values of
+// different stations can't be put in input order
+func addRollups(results map[string]Agg, sep string) {
+	groups := make(map[string]Agg)
+	for key, v := range results {
+		prefix, _, ok := strings.Cut(key, sep)
+		if !ok {
+			continue
+		}
+		group := prefix + sep
+		agg, ok := groups[group]
+		if !ok {
+			// starts empty rather than from a copy of station, which would share its counts map
+			agg = Agg{min: math.Inf(1), max: math.Inf(-1)}
+		}
+		agg.Merge(v)
+		groups[group] = agg
+	}
+	for group, agg := range groups {
+		if _, ok := results[group]; ok {
+			panic(fmt.Errorf("station %q has the same name as its -rollup group", group))
+		}
+		agg.recent = nil
+		results[group] = agg
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRollup(t *testing.T) {
+	data := "France/Paris;12.0\nFrance/Lyon;-3.5\nGermany/Berlin;4.0\nFrance/Paris;20.5\nOslo;-8.0\nGermany/Hamburg;1.5\n"
+	setFlags(t, "-rollup", "prefix:/")
+	results := aggregate(data, 2)
+
+	want := "{France/=-3.5/9.7/20.5, France/Lyon=-3.5/-3.5/-3.5, France/Paris=12.0/16.3/20.5, " +
+		"Germany/=1.5/2.8/4.0, Germany/Berlin=4.0/4.0/4.0, Germany/Hamburg=1.5/1.5/1.5, Oslo=-8.0/-8.0/-8.0}"
+	if got := formatResults(results, "brc"); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	// group is the same as its stations aggregated under one name
+	setFlags(t)
+	grouped := aggregate(strings.NewReplacer("Paris", "", "Lyon", "", "Berlin", "", "Hamburg", "").Replace(data), 1)
+	for _, group := range []string{"France/", "Germany/"} {
+		got, want := results[group], grouped[group]
+		if got.count != want.count || got.sum != want.sum || got.min != want.min || got.max != want.max {
+			t.Errorf("%s: got %+v, want %+v", group, got, want)
+		}
+	}
+	// and its count is the sum of counts of its stations
+	if n := results["France/Paris"].count + results["France/Lyon"].count; results["France/"].count != n {
+		t.Errorf("France/: got count %d, want %d", results["France/"].count, n)
+	}
+
+	// global is of stations only
+	setFlags(t, "-rollup", "prefix:/", "-global")
+	if got := aggregate(data, 1)[globalKey]; got.count != 6 {
+		t.Errorf("global: got count %d, want 6", got.count)
+	}
+
+	// recent values of stations can't be merged in input order, groups have none
+	setFlags(t, "-rollup", "prefix:/", "-recent", "2")
+	results = aggregate(data, 1)
+	if r := results["France/"].recent; r != nil {
+		t.Errorf("France/: got recent values %v", r.ordered())
+	}
+	path := filepath.Join(t.TempDir(), recentPath)
+	writeRecent(results, path)
+	if got, _ := os.ReadFile(path); strings.Contains(string(got), "France/:") {
+		t.Errorf("got group in %q", got)
+	}
+
+	setFlags(t, "-rollup", "prefix:/")
+	if msg := panicMessage(t, func() { aggregate("A/;1.0\nA/B;2.0\n", 1) }); !strings.Contains(msg, `"A/"`) {
+		t.Errorf("got panic %s", msg)
+	}
+}
+
+func TestParseRollup(t *testing.T) {
+	for spec, want := range map[string]string{"prefix:/": "/", "prefix::": ":", "prefix:, ": ", "} {
+		if sep, err := parseRollup(spec); err != nil || sep != want {
+			t.Errorf("%s: got %q, %v", spec, sep, err)
+		}
+	}
+	for _, spec := range []string{"", "prefix", "prefix:", "suffix:/", "/"} {
+		if _, err := parseRollup(spec); err == nil {
+			t.Errorf("%s: no error", spec)
+		}
+	}
+}
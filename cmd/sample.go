@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"math"
+)
+
+// sampled reports whether line at position pos (byte offset in input, or line number) is taken by -sample-rate.
+// Decision depends only on position, so the same rows are taken whatever the number of workers or chunk size
+// (with -stream offsets are within buffer, so they depend on -stream-buffer).
+// Hash of line content would be worse: 1BRC lines repeat a lot and copies would be taken or skipped together
+func sampled(pos uint64) bool {
+	// murmur3 finalizer, consecutive positions get unrelated hashes
+	h := pos
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h < opts.sampleLimit
+}
+
+// skipUnsampled returns position of the next line if line starting at data[i] is not sampled
+func skipUnsampled(data []byte, i int) (next int, skip bool) {
+	if sampled(uint64(i)) {
+		return i, false
+	}
+	if nl := bytes.IndexByte(data[i:], '\n'); nl >= 0 {
+		return i + nl + 1, true
+	}
+	return len(data), true
+}
+
+// sampleLimit returns hash threshold taking rate fraction of lines
+func sampleLimit(rate float64) uint64 {
+	if rate >= 1 {
+		return math.MaxUint64
+	}
+	return uint64(rate * (1 << 64))
+}
+
+// scaleSample extrapolates aggregates of sampled rows to the whole input: count is divided by -sample-rate
+// and sums are scaled by the same factor, so means and variances stay as sampled.
+// Counts of distinct values are scaled too, so they still add up to count for quantiles and trimmed mean.
+// min and max are of the sample only, they can't be extrapolated
+func scaleSample(results map[string]Agg) {
+	for key, v := range results {
+		count := int(math.Round(float64(v.count) / opts.sampleRate))
+		k := float64(count) / float64(v.count)
+		v.count = count
+		v.sum *= k
+		v.sumLog *= k
+		v.sumSq *= k
+		v.sumRecip *= k
+		v.m2 *= k
+		v.bytes = int(math.Round(float64(v.bytes) * k))
+		if v.counts != nil {
+			v.counts = scaleCounts(v.counts, k)
+		}
+		results[key] = v
+	}
+}
+
+// scaleCounts returns counts of distinct values multiplied by k. Running total is rounded rather than every count,
+// so scaled counts add up to the scaled total exactly and rounding errors don't accumulate.
+// counts is left as is: with -flush-interval or -snapshot-dir it's still shared with the map scan merges into
+func scaleCounts(counts map[float64]int, k float64) map[float64]int {
+	values := Agg{counts: counts}.distinctValues()
+	scaledCounts := make(map[float64]int, len(counts))
+	var seen, scaled int // totals before current value, as sampled and scaled
+	for _, value := range values {
+		seen += counts[value]
+		total := int(math.Round(float64(seen) * k))
+		if total > scaled {
+			scaledCounts[value] = total - scaled
+		}
+		scaled = total
+	}
+	return scaledCounts
+}
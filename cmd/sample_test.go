@@ -0,0 +1,104 @@
+package main
+
+import (
+	"maps"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSampleRate(t *testing.T) {
+	data := genMeasurements(100000, 50)
+	setFlags(t, "-sample-rate", "0.1", "-iqr", "-trimmed-mean", "0.1")
+
+	// ~10% of rows, binomial stddev is ~95 rows
+	sampled := countRows(reduce(mapScan(data, scan, 1)...))
+	if sampled < 9700 || sampled > 10300 {
+		t.Errorf("got %d sampled rows of 100000, want ~10000", sampled)
+	}
+	// the same rows whatever the split into chunks
+	setFlags(t, "-sample-rate", "0.1", "-iqr", "-trimmed-mean", "0.1", "-chunk-bytes", "4096")
+	raw := reduce(mapScan(data, scan, 4)...)
+	if got := countRows(raw); got != sampled {
+		t.Errorf("got %d sampled rows with 4KB chunks, want %d", got, sampled)
+	}
+
+	means := make(map[string]float64, len(raw))
+	for key, v := range raw {
+		means[key] = v.mean()
+	}
+	results := prepareResults(raw)
+	total := 0
+	for key, v := range results {
+		total += v.count
+		// scaling keeps the sampled mean and estimates the count
+		if math.Abs(v.mean()-means[key]) > 1e-9 {
+			t.Errorf("%s: mean %v changed from sampled %v", key, v.mean(), means[key])
+		}
+		// counts of distinct values still add up to count, so quantiles are of the whole estimate
+		sum := 0
+		for _, n := range v.counts {
+			sum += n
+		}
+		if sum != v.count {
+			t.Errorf("%s: counts of values add up to %d, count is %d", key, sum, v.count)
+		}
+		if iqr, tm := v.iqr(), v.trimmedMean(0.1); math.IsNaN(iqr) || iqr < 0 || tm < v.min || tm > v.max {
+			t.Errorf("%s: got iqr %v, trimmed mean %v of [%v, %v]", key, iqr, tm, v.min, v.max)
+		}
+	}
+	if total < 97000 || total > 103000 {
+		t.Errorf("got estimated count %d, want ~100000", total)
+	}
+}
+
+func TestScaleCounts(t *testing.T) {
+	for _, tt := range []struct {
+		counts map[float64]int
+		k      float64
+		want   map[float64]int
+	}{
+		{map[float64]int{1: 1, 2: 2, 3: 1}, 10, map[float64]int{1: 10, 2: 20, 3: 10}},
+		// running total 1.7, 3.3, 5 is rounded to 2, 3, 5
+		{map[float64]int{1: 1, 2: 1, 3: 1}, 5.0 / 3, map[float64]int{1: 2, 2: 1, 3: 2}},
+		// scaled below one row, value is dropped
+		{map[float64]int{1: 1, 2: 1, 3: 1}, 0.5, map[float64]int{1: 1, 3: 1}},
+	} {
+		sampledCounts := maps.Clone(tt.counts)
+		got := scaleCounts(tt.counts, tt.k)
+		if !maps.Equal(got, tt.want) {
+			t.Errorf("k=%v: got %v, want %v", tt.k, got, tt.want)
+		}
+		if !maps.Equal(tt.counts, sampledCounts) {
+			t.Errorf("k=%v: sampled counts changed to %v", tt.k, tt.counts)
+		}
+	}
+}
+
+func TestSampleFlush(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "in.txt"), genMeasurements(100000, 20), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	args := []string{"-input", "in.txt", "-stream", "-stream-buffer", "65536",
+		"-sample-rate", "0.5", "-trimmed-mean", "0.1", "-iqr", "-format", "csv"}
+	if out, code := runMain(t, dir, append(args, "-output", "once.csv")...); code != 0 {
+		t.Fatalf("exit %d: %s", code, out)
+	}
+	// every flush scales the same counts of the running results, they must not be scaled twice
+	if out, code := runMain(t, dir, append(args, "-output", "flushed.csv", "-flush-interval", "1ns")...); code != 0 {
+		t.Fatalf("exit %d: %s", code, out)
+	}
+	once, err := os.ReadFile(filepath.Join(dir, "once.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	flushed, err := os.ReadFile(filepath.Join(dir, "flushed.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(flushed) != string(once) {
+		t.Errorf("got with -flush-interval:\n%s\nwant:\n%s", flushed, once)
+	}
+}
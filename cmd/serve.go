@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"time"
+)
+
+// serveTimeout bounds writing results to a client, so stalled clients don't pile up
+const serveTimeout = 30 * time.Second
+
+// serveResults listens on addr and writes results in opts.format to every client which connects,
+// then closes its connection. It's plain TCP (e.g. `nc host 9000`), not HTTP,
+// and it serves until process is killed. Output is formatted once, clients are served concurrently
+func serveResults(addr string, results map[string]Agg) {
+	var out bytes.Buffer
+	formats[opts.format](results, sortedKeys(results), &out)
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatalf("can't serve results: %s", err)
+	}
+	fmt.Fprintf(diag, "serving results on %s\n", ln.Addr())
+	serve(ln, out.Bytes())
+}
+
+// serve writes out to every client accepted by ln until ln is closed
+func serve(ln net.Listener, out []byte) {
+	for {
+		conn, err := ln.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "accept: %s\n", err) // e.g. out of file descriptors, next one may succeed
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		go func() {
+			defer conn.Close()
+			conn.SetWriteDeadline(time.Now().Add(serveTimeout))
+			if _, err := conn.Write(out); err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %s\n", conn.RemoteAddr(), err)
+			}
+		}()
+	}
+}
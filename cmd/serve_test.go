@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// readServed connects to addr and returns everything server writes
+func readServed(t *testing.T, addr string) string {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Error(err)
+		return ""
+	}
+	defer conn.Close()
+	b, err := io.ReadAll(conn)
+	if err != nil {
+		t.Error(err)
+	}
+	return string(b)
+}
+
+func TestServe(t *testing.T) {
+	setFlags(t, "-format", "json")
+	want := formatResults(aggregate("Oslo;-3.5\nHamburg;12.0\nHamburg;-1.0\n", 1), "json")
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		serve(ln, []byte(want))
+		close(done)
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if got := readServed(t, ln.Addr().String()); got != want {
+				t.Errorf("got\n%s\nwant\n%s", got, want)
+			}
+		}()
+	}
+	wg.Wait()
+	ln.Close()
+	<-done
+}
+
+func TestServeMain(t *testing.T) {
+	dir := filepath.Dir(writeFile(t, "in.txt", "Oslo;-3.5\nHamburg;12.0\n"))
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(exe, "-input", "in.txt", "-no-profile", "-serve", "127.0.0.1:0")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "BRC_TEST_MAIN=1")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+
+	var addr string
+	for sc := bufio.NewScanner(stdout); addr == "" && sc.Scan(); {
+		if after, ok := strings.CutPrefix(sc.Text(), "serving results on "); ok {
+			addr = after
+		}
+	}
+	if addr == "" {
+		t.Fatal("server address is not printed")
+	}
+	for i := 0; i < 2; i++ {
+		if got, want := readServed(t, addr), "{Hamburg=12.0/12.0/12.0, Oslo=-3.5/-3.5/-3.5}"; got != want {
+			t.Errorf("client %d: got %q, want %q", i, got, want)
+		}
+	}
+
+	if out, code := runMain(t, dir, "-input", "in.txt", "-serve", ":0", "-assert-output", "in.txt"); code != 2 {
+		t.Errorf("got exit code %d with -assert-output, output %s", code, out)
+	}
+}
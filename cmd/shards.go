@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// shardPaths returns paths of n output parts and manifest for output path,
+// e.g. result.part0.json, result.part1.json, ... and result.manifest for result.json.
+// Parts keep extension of output, so their format is still told by it
+func shardPaths(output string, n int) (parts []string, manifest string) {
+	for i := 0; i < n; i++ {
+		parts = append(parts, partPath(output, i))
+	}
+	ext := filepath.Ext(output)
+	return parts, strings.TrimSuffix(output, ext) + ".manifest"
+}
+
+// partPath returns path of part i of output
+func partPath(output string, i int) string {
+	ext := filepath.Ext(output)
+	return fmt.Sprintf("%s.part%d%s", strings.TrimSuffix(output, ext), i, ext)
+}
+
+// writeShards splits stations in output order into n contiguous groups
+// and writes each group in output format to its own part concurrently.
+// Every part is a complete file of its format (with its own brc braces, CSV header
+// or JSON array), so parts are not meant to be concatenated: stations of parts
+// read in manifest order are the full output in its order.
+// Manifest lists parts in order with number of stations in each.
+// Stations are sorted once: parts get their slices of keys, as opts.collator can't be used concurrently.
+// Parts left by an earlier run into more parts are removed, so parts on disk are the ones of manifest
+// (unless -no-clobber is set, then nothing is removed)
+func writeShards(results map[string]Agg, out output, n int) {
+	keys := sortedKeys(results)
+	n = max(min(n, len(keys)), 1)
+	parts, manifest := shardPaths(out.path, n)
+
+	shards := make([][]string, n)
+	for i := range shards {
+		shards[i] = keys[len(keys)*i/n : len(keys)*(i+1)/n]
+	}
+
+	var wg sync.WaitGroup
+	for i := range shards {
+		i := i
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			writeResults(results, shards[i], parts[i], out.format)
+		}()
+	}
+	wg.Wait()
+
+	writeFileAtomic(manifest, opts.noClobber, func(w io.Writer) {
+		for i, part := range parts {
+			fmt.Fprintf(w, "%s %d\n", filepath.Base(part), len(shards[i]))
+		}
+	})
+	if !opts.noClobber {
+		removeParts(out.path, n)
+	}
+}
+
+// removeParts removes parts of output from number from on, up to the first missing one
+func removeParts(output string, from int) {
+	for i := from; ; i++ {
+		err := os.Remove(partPath(output, i))
+		if errors.Is(err, os.ErrNotExist) {
+			return
+		}
+		if err != nil {
+			panic(err)
+		}
+	}
+}
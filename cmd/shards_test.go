@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestShardPaths(t *testing.T) {
+	parts, manifest := shardPaths("out/result.json", 2)
+	if fmt.Sprint(parts) != "[out/result.part0.json out/result.part1.json]" || manifest != "out/result.manifest" {
+		t.Errorf("got %v and %s", parts, manifest)
+	}
+}
+
+func TestParallelWrite(t *testing.T) {
+	// entries returns stations of output in order, one formatted string per station
+	entries := map[string]func(t *testing.T, content string) []string{
+		"brc": func(t *testing.T, content string) []string {
+			return strings.Split(strings.TrimSuffix(strings.TrimPrefix(content, "{"), "}"), ", ")
+		},
+		"csv": func(t *testing.T, content string) []string {
+			lines := strings.Split(strings.TrimSpace(content), "\n")
+			return lines[1:] // header is in every part
+		},
+		"json": func(t *testing.T, content string) []string {
+			var objects []map[string]any
+			if err := json.Unmarshal([]byte(content), &objects); err != nil {
+				t.Fatal(err)
+			}
+			var out []string
+			for _, o := range objects {
+				out = append(out, fmt.Sprint(o))
+			}
+			return out
+		},
+	}
+	var data strings.Builder
+	for i := 0; i < 10; i++ {
+		fmt.Fprintf(&data, "S%02d;%d.5\n", 9-i, i)
+	}
+
+	for format, parse := range entries {
+		t.Run(format, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "result."+format)
+			setFlags(t, "-parallel-write", "3", "-format", format, "-output", path)
+			results := aggregate(data.String(), 1)
+			want := parse(t, formatResults(results, format))
+			writeResultsToFile(results)
+
+			manifest, err := os.ReadFile(filepath.Join(filepath.Dir(path), "result.manifest"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, line := range strings.Split(strings.TrimSpace(string(manifest)), "\n") {
+				var (
+					name     string
+					stations int
+				)
+				if _, err := fmt.Sscanf(line, "%s %d", &name, &stations); err != nil {
+					t.Fatalf("bad manifest line %q", line)
+				}
+				if filepath.Ext(name) != "."+format {
+					t.Errorf("part %s has no extension of output", name)
+				}
+				content, err := os.ReadFile(filepath.Join(filepath.Dir(path), name))
+				if err != nil {
+					t.Fatal(err)
+				}
+				part := parse(t, string(content))
+				if len(part) != stations {
+					t.Errorf("%s: %d stations, manifest says %d", name, len(part), stations)
+				}
+				got = append(got, part...)
+			}
+			if strings.Join(got, "\n") != strings.Join(want, "\n") {
+				t.Errorf("parts in manifest order:\n%s\nfull output:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+			}
+		})
+	}
+}
+
+func TestParallelWriteLocale(t *testing.T) {
+	// ä goes after z in bytes order, but with a and before b with German rules
+	var data strings.Builder
+	for i := 0; i < 200; i++ {
+		fmt.Fprintf(&data, "%c%03d;1.0\n", []rune("aäbz")[i%4], i)
+	}
+	path := filepath.Join(t.TempDir(), "result.csv")
+	setFlags(t, "-parallel-write", "8", "-locale", "de", "-output", path)
+	results := aggregate(data.String(), 1)
+	want := strings.Split(strings.TrimSpace(formatResults(results, "csv")), "\n")[1:]
+	writeResultsToFile(results)
+
+	var got []string
+	for i := 0; i < 8; i++ {
+		content, err := os.ReadFile(partPath(path, i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, strings.Split(strings.TrimSpace(string(content)), "\n")[1:]...)
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("parts in order:\n%s\nfull output:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestParallelWriteStaleParts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "result.txt")
+	setFlags(t, "-parallel-write", "4", "-output", path)
+	results := aggregate("A;1.0\nB;2.0\nC;3.0\nD;4.0\n", 1)
+	writeResultsToFile(results)
+
+	// fewer parts of the next run replace the first ones, the rest is removed
+	setFlags(t, "-parallel-write", "2", "-output", path)
+	writeResultsToFile(results)
+	for i, want := range []bool{true, true, false, false} {
+		if _, err := os.Stat(partPath(path, i)); (err == nil) != want {
+			t.Errorf("part %d exists: %v, want %v", i, err == nil, want)
+		}
+	}
+}
+
+func TestParallelWriteNoClobber(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "in.txt"), []byte("A;1.0\nB;2.0\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "result.part1.txt"), []byte("precious"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	out, code := runMain(t, dir, "-input", "in.txt", "-parallel-write", "2", "-no-clobber")
+	if code == 0 || !strings.Contains(out, "result.part1.txt already exists") {
+		t.Errorf("exit %d: %s", code, out)
+	}
+	if content, _ := os.ReadFile(filepath.Join(dir, "result.part1.txt")); string(content) != "precious" {
+		t.Errorf("part is overwritten with %q", content)
+	}
+}
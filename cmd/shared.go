@@ -0,0 +1,59 @@
+package main
+
+import "sync"
+
+// sharedShards is number of independently locked parts of sharedTable,
+// many more than workers so two workers rarely wait for the same shard
+const sharedShards = 256
+
+// sharedTable is a single table of aggregates all workers update concurrently,
+// instead of per-worker maps merged by reduce. Stations are spread over shards
+// by hash of name, every shard is guarded by its own mutex
+type sharedTable struct {
+	shards [sharedShards]struct {
+		mu sync.Mutex
+		m  map[string]*Agg
+		_  [48]byte // pads shard to 64 bytes, keeps neighbouring mutexes out of the same cache line
+	}
+}
+
+// shared is nil unless -shared-table is set
+var shared *sharedTable
+
+func newSharedTable() *sharedTable {
+	t := &sharedTable{}
+	for i := range t.shards {
+		t.shards[i].m = make(map[string]*Agg)
+	}
+	return t
+}
+
+// add accounts value of station key, its record took size bytes of input
+func (t *sharedTable) add(key []byte, value float64, size int) {
+	shard := &t.shards[stationHash(key)%sharedShards]
+
+	shard.mu.Lock()
+	agg := shard.m[string(key)]
+	if agg != nil {
+		agg.Add(value)
+	} else {
+		newValue := newAgg(value)
+		agg = &newValue
+		shard.m[string(key)] = agg
+	}
+	if opts.byteStats {
+		agg.bytes += size
+	}
+	shard.mu.Unlock()
+}
+
+// results returns aggregates of all shards, must be called after workers are done
+func (t *sharedTable) results() map[string]Agg {
+	out := make(map[string]Agg, stationsHint())
+	for i := range t.shards {
+		for key, agg := range t.shards[i].m {
+			out[key] = *agg
+		}
+	}
+	return out
+}
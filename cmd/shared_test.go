@@ -0,0 +1,40 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// sharedAggregate is aggregate with workers updating shared table
+func sharedAggregate(data string, workers int) map[string]Agg {
+	shared = newSharedTable()
+	defer func() { shared = nil }()
+	mapScan(terminateLastLine([]byte(data)), scan, workers)
+	return prepareResults(shared.results())
+}
+
+func TestSharedTable(t *testing.T) {
+	data := string(genMeasurements(20000, 700))
+	for _, args := range [][]string{
+		{"-chunk-bytes", "4096"},
+		{"-chunk-bytes", "4096", "-format", "csv", "-variance", "-byte-stats", "-iqr"},
+		{"-chunk-bytes", "0", "-format", "csv", "-include", "^Station1"},
+	} {
+		setFlags(t, args...)
+		want := formatResults(aggregate(data, 4), opts.format)
+		setFlags(t, append(args, "-shared-table")...)
+		for _, workers := range []int{1, 4, 16} {
+			if got := formatResults(sharedAggregate(data, workers), opts.format); got != want {
+				t.Errorf("%v, %d workers: got\n%.300s\nwant\n%.300s", args, workers, got, want)
+			}
+		}
+	}
+}
+
+func TestSharedTablePerWorkerStats(t *testing.T) {
+	// workers have no maps of their own to count rows of
+	out, code := runMain(t, t.TempDir(), "-shared-table", "-per-worker-stats")
+	if code != 2 || !strings.Contains(out, "-per-worker-stats") {
+		t.Errorf("exit %d: %s", code, out)
+	}
+}
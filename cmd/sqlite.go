@@ -0,0 +1,62 @@
+//go:build sqlite
+
+package main
+
+import (
+	"database/sql"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func init() {
+	fileFormats["sqlite"] = writeSQLite
+	formatExts[".db"] = "sqlite"
+	fixedFields["sqlite"] = []field{
+		{"name", "string", baseFields[0].value},
+		baseFields[1],
+		baseFields[2],
+		baseFields[3],
+		{"count", "int", func(_ string, v Agg) any { return v.count }},
+	}
+}
+
+// writeSQLite writes results into
+// `stations(name TEXT, min REAL, mean REAL, max REAL, count INTEGER)` table of SQLite database at path
+func writeSQLite(data map[string]Agg, keys []string, path string) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		panic(err)
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		panic(err)
+	}
+	_, err = tx.Exec(`CREATE TABLE stations (name TEXT PRIMARY KEY, min REAL, mean REAL, max REAL, count INTEGER)`)
+	if err != nil {
+		panic(err)
+	}
+	stmt, err := tx.Prepare(`INSERT INTO stations (name, min, mean, max, count) VALUES (?, ?, ?, ?, ?)`)
+	if err != nil {
+		panic(err)
+	}
+	for _, key := range keys {
+		v, ok := data[key]
+		var err error
+		if ok {
+			_, err = stmt.Exec(key, round(v.min), round(v.sum/float64(v.count)), round(v.max), v.count)
+		} else {
+			_, err = stmt.Exec(key, nil, nil, nil, 0)
+		}
+		if err != nil {
+			panic(err)
+		}
+	}
+	if err := stmt.Close(); err != nil {
+		panic(err)
+	}
+	if err := tx.Commit(); err != nil {
+		panic(err)
+	}
+}
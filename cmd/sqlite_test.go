@@ -0,0 +1,41 @@
+//go:build sqlite
+
+package main
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+)
+
+func TestSQLiteOutput(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "results.db")
+	setFlags(t, "-output", path)
+	writeResultsToFile(aggregate("Hamburg;12.0\nOslo;-3.5\nHamburg;-1.0\n", 1))
+
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var (
+		minValue, mean, maxValue float64
+		count                    int
+	)
+	row := db.QueryRow(`SELECT min, mean, max, count FROM stations WHERE name = ?`, "Hamburg")
+	if err := row.Scan(&minValue, &mean, &maxValue, &count); err != nil {
+		t.Fatal(err)
+	}
+	if minValue != -1 || mean != 5.5 || maxValue != 12 || count != 2 {
+		t.Errorf("got %v/%v/%v count %d, want -1/5.5/12 count 2", minValue, mean, maxValue, count)
+	}
+
+	var stations int
+	if err := db.QueryRow(`SELECT count(*) FROM stations`).Scan(&stations); err != nil {
+		t.Fatal(err)
+	}
+	if stations != 2 {
+		t.Errorf("got %d stations, want 2", stations)
+	}
+}
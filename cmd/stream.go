@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"io"
+)
+
+// chunkReader reads input by buffers cut at the last record end,
+// tail of incomplete record is carried to the beginning of the next chunk
+type chunkReader struct {
+	r    io.Reader
+	buf  []byte
+	tail []byte // incomplete record after the end of chunk returned last time
+	done bool
+}
+
+func newChunkReader(r io.Reader, size int) *chunkReader {
+	return &chunkReader{r: r, buf: make([]byte, size)}
+}
+
+// next returns next record aligned chunk, nil at the end of input.
+// Returned slice is valid until the next call
+func (c *chunkReader) next() ([]byte, error) {
+	chunk, buf, err := c.read(c.buf)
+	c.buf = buf
+	return chunk, err
+}
+
+// read fills buf with carried over tail and the following input and returns record aligned chunk of it
+// (nil at the end of input), and buf itself which is grown if record is longer than buf.
+// Tail stays in buf until the next call, so buf must not be overwritten before it
+func (c *chunkReader) read(buf []byte) (chunk []byte, _ []byte, err error) {
+	if c.done {
+		return nil, buf, nil
+	}
+
+	if len(c.tail) >= len(buf) {
+		buf = make([]byte, 2*len(c.tail))
+	}
+	// carry over incomplete record, it may overlap (with the single buffer of next)
+	end := copy(buf, c.tail)
+	c.tail = nil
+
+	for {
+		// Read may return less than asked without any error (pipes, network),
+		// so ReadFull loops until buffer is full or input is over.
+		// Otherwise chunk would be cut at an arbitrary short read
+		n, err := io.ReadFull(c.r, buf[end:])
+		end += n
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			c.done = true
+			chunk = terminateLastLine(buf[:end])
+			if len(chunk) == 0 {
+				return nil, buf, nil
+			}
+			return chunk, chunk[:cap(chunk)], nil
+		}
+		if err != nil {
+			return nil, buf, err
+		}
+
+		cut := bytes.LastIndexByte(buf[:end], '\n') + 1
+		if cut > 0 {
+			c.tail = buf[cut:end]
+			return buf[:cut], buf, nil
+		}
+
+		// record is longer than buffer
+		buf = append(buf, make([]byte, len(buf))...)
+	}
+}
+
+// prefetched is a chunk read ahead by prefetch, buf is returned to its pool after processing
+type prefetched struct {
+	chunk []byte
+	buf   []byte
+	err   error
+}
+
+// prefetch reads chunks of cr in background into n buffers of size bytes,
+// so next chunks are read while workers process the current one.
+// Buffer has to be sent to free when its chunk is processed
+func prefetch(cr *chunkReader, n, size int) (chunks <-chan prefetched, free chan<- []byte) {
+	out := make(chan prefetched, n)
+	pool := make(chan []byte, n)
+	for i := 0; i < n; i++ {
+		pool <- make([]byte, size)
+	}
+
+	go func() {
+		defer close(out)
+		for buf := range pool {
+			// tail of the previous chunk is copied at the beginning of read,
+			// and buffers are written only here, so it can't be overwritten before
+			chunk, buf, err := cr.read(buf)
+			if chunk == nil && err == nil {
+				return
+			}
+			out <- prefetched{chunk: chunk, buf: buf, err: err}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return out, pool
+}
+
+// scanStream processes input chunk by chunk, memory is bounded by opts.streamBuffer
+// (plus aggregates), so input doesn't have to fit in memory or even end
+func scanStream(r io.Reader, workers int) map[string]Agg {
+	return scanStreamInto(make(map[string]Agg, stationsHint()), r, workers)
+}
+
+// scanStreamInto merges aggregates of r into merged and returns it
+func scanStreamInto(merged map[string]Agg, r io.Reader, workers int) map[string]Agg {
+	add := func(chunk []byte) {
+		results := mapScan(chunk, scan, workers)
+		merged = reduce(append([]map[string]Agg{merged}, results...)...)
+		flushes.maybe(func() map[string]Agg { return merged })
+		if opts.snapshotEvery > 0 {
+			snapshots.maybe(countRows(merged), func() map[string]Agg { return merged })
+		}
+	}
+
+	if opts.prefetchBuffers > 1 {
+		chunks, free := prefetch(&chunkReader{r: r}, opts.prefetchBuffers, opts.streamBuffer)
+		for p := range chunks {
+			if p.err != nil {
+				panic(p.err)
+			}
+			if stopped() {
+				return merged // reader goroutine stays blocked on full channel, run is over anyway
+			}
+			add(p.chunk)
+			free <- p.buf
+		}
+		return merged
+	}
+
+	cr := newChunkReader(r, opts.streamBuffer)
+	for {
+		chunk, err := cr.next()
+		if err != nil {
+			panic(err)
+		}
+		if chunk == nil || stopped() {
+			return merged
+		}
+		add(chunk)
+	}
+}
+
+// scanReaders processes readers as one logical stream, concatenated in order.
+// Record may be split between two readers (e.g. file parts cut at arbitrary byte),
+// chunkReader carries its beginning over to the next chunk like for any other buffer boundary
+func scanReaders(workers int, readers ...io.Reader) map[string]Agg {
+	return scanStream(io.MultiReader(readers...), workers)
+}
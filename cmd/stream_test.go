@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestChunkReaderShortReads(t *testing.T) {
+	data := genMeasurements(2000, 20)
+	for _, size := range []int{7, 64, 4096} { // 7 is shorter than most records
+		cr := newChunkReader(iotest.OneByteReader(bytes.NewReader(data)), size)
+		var (
+			got   []byte
+			sizes []int
+		)
+		for {
+			chunk, err := cr.next()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if chunk == nil {
+				break
+			}
+			if chunk[len(chunk)-1] != '\n' {
+				t.Fatalf("size %d: chunk ends inside record: %q", size, chunk[max(len(chunk)-20, 0):])
+			}
+			got = append(got, chunk...)
+			sizes = append(sizes, len(chunk))
+		}
+		// chunk is cut at the last record end of full buffer, only the last one is shorter
+		for _, n := range sizes[:len(sizes)-1] {
+			if size > 16 && n <= size-16 {
+				t.Fatalf("size %d: chunks of %v bytes, buffer isn't filled", size, sizes)
+			}
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("size %d: chunks differ from input", size)
+		}
+	}
+}
+
+func TestScanStreamShortReads(t *testing.T) {
+	data := genMeasurements(5000, 50)
+	setFlags(t, "-stream", "-stream-buffer", "1000")
+	want := formatResults(aggregate(string(data), 2), "brc")
+	got := formatResults(scanStream(iotest.OneByteReader(bytes.NewReader(data)), 2), "brc")
+	if got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestScanReadersSplitRecord(t *testing.T) {
+	setFlags(t, "-stream", "-stream-buffer", "64")
+	const data = "Hamburg;12.0\nBulawayo;8.9\nPalembang;38.8\nHamburg;-3.4\n"
+	const want = "{Bulawayo=8.9/8.9/8.9, Hamburg=-3.4/4.3/12.0, Palembang=38.8/38.8/38.8}"
+	// every split point, including inside names, values and right before newline
+	for i := 0; i <= len(data); i++ {
+		a, b := strings.NewReader(data[:i]), strings.NewReader(data[i:])
+		if got := formatResults(scanReaders(2, a, b), "brc"); got != want {
+			t.Errorf("split at %d (%q|%q): got %s", i, data[:i], data[i:], got)
+		}
+	}
+
+	// the same for a line straddling three readers and buffer boundary
+	sample := genMeasurements(5000, 50)
+	mid := bytes.IndexByte(sample[len(sample)/2:], ';') + len(sample)/2
+	wantSample := formatResults(aggregate(string(sample), 2), "brc")
+	got := formatResults(scanReaders(2,
+		bytes.NewReader(sample[:mid-2]), bytes.NewReader(sample[mid-2:mid+2]), bytes.NewReader(sample[mid+2:])), "brc")
+	if got != wantSample {
+		t.Errorf("three readers: got\n%s\nwant\n%s", got, wantSample)
+	}
+}
+
+func TestPrefetch(t *testing.T) {
+	data := genMeasurements(5000, 50)
+	// records longer than buffer make it grow
+	data = append(data, strings.Repeat("Long name ", 30)+";1.0\n"...)
+	setFlags(t)
+	want := formatResults(aggregate(string(data), 2), "brc")
+	for _, buffers := range []string{"1", "2", "3", "8"} {
+		for _, size := range []string{"100", "1000", "65536"} {
+			setFlags(t, "-stream", "-stream-buffer", size, "-prefetch-buffers", buffers)
+			got := formatResults(scanStream(iotest.HalfReader(bytes.NewReader(data)), 2), "brc")
+			if got != want {
+				t.Errorf("%s buffers of %s bytes: results differ", buffers, size)
+			}
+		}
+	}
+}
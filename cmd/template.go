@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"text/template"
+)
+
+// templateData is passed to "header" and "footer" templates
+type templateData struct {
+	Stations int
+	Fields   []string
+}
+
+// loadTemplate parses -output-template-file. Main template is rendered per station
+// with map of output field names to formatted values (e.g. {{.station}}={{.mean}}),
+// optional {{define "header"}} and {{define "footer"}} are rendered once with templateData.
+// Template is rendered on a sample station right away, so mistakes like unknown fields
+// are reported before the run
+func loadTemplate(path string) (*template.Template, error) {
+	tmpl, err := template.New(path).Option("missingkey=error").ParseFiles(path)
+	if err != nil {
+		return nil, err
+	}
+	// ParseFiles names template by file base name
+	tmpl = tmpl.Lookup(filepath.Base(path))
+
+	sample := make(map[string]string)
+	for _, f := range outputFields() {
+		sample[f.name] = notAvailable
+	}
+	if err := renderTemplate(tmpl, io.Discard, []map[string]string{sample}); err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+// renderTemplate writes header, template per station and footer
+func renderTemplate(tmpl *template.Template, w io.Writer, stations []map[string]string) error {
+	var names []string
+	for _, f := range outputFields() {
+		names = append(names, f.name)
+	}
+	data := templateData{Stations: len(stations), Fields: names}
+
+	if header := tmpl.Lookup("header"); header != nil {
+		if err := header.Execute(w, data); err != nil {
+			return err
+		}
+	}
+	for _, station := range stations {
+		if err := tmpl.Execute(w, station); err != nil {
+			return err
+		}
+	}
+	if footer := tmpl.Lookup("footer"); footer != nil {
+		return footer.Execute(w, data)
+	}
+	return nil
+}
+
+// printTemplate writes results with opts.template, values of absent stations are N/A
+func printTemplate(data map[string]Agg, keys []string, w io.Writer) {
+	fields := outputFields()
+	var stations []map[string]string
+	for _, key := range keys {
+		v, ok := data[key]
+		station := make(map[string]string, len(fields))
+		for i, f := range fields {
+			if i > 0 && !ok {
+				station[f.name] = notAvailable
+				continue
+			}
+			station[f.name] = formatValue(f.value(key, v))
+		}
+		stations = append(stations, station)
+	}
+	if err := renderTemplate(opts.template, w, stations); err != nil {
+		panic(fmt.Errorf("-output-template-file: %w", err))
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTemplateFile(t *testing.T) {
+	path := writeFile(t, "report.tmpl", ""+
+		`{{define "header"}}# {{.Stations}} stations:{{range .Fields}} {{.}}{{end}}`+"\n"+`{{end}}`+
+		`{{define "footer"}}# end{{end}}`+
+		`{{.station}} {{.min}}..{{.max}} (mean {{.mean}})`+"\n")
+	setFlags(t, "-output-template-file", path, "-keys-file", writeFile(t, "keys.txt", "Oslo\nNowhere\nHamburg\n"))
+	if opts.format != "template" {
+		t.Errorf("got format %s, want template", opts.format)
+	}
+
+	got := formatResults(aggregate("Hamburg;12.0\nOslo;-3.5\nHamburg;-1.0\n", 1), "template")
+	want := "" +
+		"# 3 stations: station min mean max\n" +
+		"Oslo -3.5..-3.5 (mean -3.5)\n" +
+		"Nowhere N/A..N/A (mean N/A)\n" +
+		"Hamburg -1.0..12.0 (mean 5.5)\n" +
+		"# end"
+	if got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestLoadTemplateErrors(t *testing.T) {
+	setFlags(t)
+	for _, tt := range []struct {
+		name, content, err string
+	}{
+		{"syntax", "{{.station", "unclosed action"},
+		{"unknown field", "{{.station}} {{.median}}\n", `map has no entry for key "median"`},
+		{"unknown header field", `{{define "header"}}{{.Rows}}{{end}}{{.station}}`, "can't evaluate field Rows"},
+	} {
+		_, err := loadTemplate(writeFile(t, "report.tmpl", tt.content))
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: got %v, want error with %q", tt.name, err, tt.err)
+		}
+	}
+	if _, err := loadTemplate(filepath.Join(t.TempDir(), "missing.tmpl")); err == nil {
+		t.Error("no error for missing file")
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// parseTimestamp parses leading timestamp field of `timestamp;station;value` record
+// which starts at data[i]. Timestamp is either unix seconds or RFC 3339.
+// Returns timestamp and position of the station field
+func parseTimestamp(data []byte, i int) (ts time.Time, next int) {
+	start := i
+	unix := true
+	var seconds int64
+	for !opts.isDelimiter[data[i]] {
+		c := data[i]
+		if c >= '0' && c <= '9' {
+			seconds = seconds*10 + int64(c-'0')
+		} else {
+			unix = false
+		}
+		i++
+	}
+
+	if unix && i > start {
+		return time.Unix(seconds, 0).UTC(), i + 1
+	}
+
+	ts, err := time.Parse(time.RFC3339, string(data[start:i]))
+	if err != nil {
+		panic(fmt.Errorf("bad timestamp: %w", err))
+	}
+	return ts, i + 1
+}
+
+// bucketKey appends `bucket|station` key to buf, bucket is ts truncated to opts.timeBucket
+func bucketKey(buf []byte, ts time.Time, station []byte) []byte {
+	buf = ts.Truncate(opts.timeBucket).UTC().AppendFormat(buf, time.RFC3339)
+	buf = append(buf, '|')
+	return append(buf, station...)
+}
+
+// stationTimes are timestamps of station records within a chunk
+type stationTimes struct {
+	first       time.Time
+	firstOffset int
+	last        time.Time
+}
+
+// timeViolation is a record whose timestamp is before the previous one
+type timeViolation struct {
+	offset int
+	record []byte
+	prev   time.Time
+}
+
+// chunkTimes tracks timestamp order of a chunk for -validate-monotonic-timestamps,
+// per station or for all records together (under empty key)
+type chunkTimes struct {
+	start     int
+	stations  map[string]*stationTimes
+	violation *timeViolation // first one in chunk
+}
+
+func newChunkTimes(start int) *chunkTimes {
+	return &chunkTimes{start: start, stations: make(map[string]*stationTimes)}
+}
+
+// add accounts record data[start:next) with timestamp ts of station key
+func (c *chunkTimes) add(data []byte, start, next int, ts time.Time, key []byte) {
+	if opts.monotonic == "global" {
+		key = nil
+	}
+	st := c.stations[string(key)]
+	if st == nil {
+		c.stations[string(key)] = &stationTimes{first: ts, firstOffset: start, last: ts}
+		return
+	}
+	if ts.Before(st.last) && c.violation == nil {
+		c.violation = &timeViolation{
+			offset: start,
+			record: append([]byte(nil), data[start:next-1]...),
+			prev:   st.last,
+		}
+	}
+	st.last = ts
+}
+
+// timeOrder collects chunkTimes of all workers
+var timeOrder struct {
+	mu     sync.Mutex
+	chunks []*chunkTimes
+}
+
+func addChunkTimes(c *chunkTimes) {
+	timeOrder.mu.Lock()
+	defer timeOrder.mu.Unlock()
+	timeOrder.chunks = append(timeOrder.chunks, c)
+}
+
+// checkMonotonic panics with the first out of order record of data, in file order.
+// Chunks are checked inside by scan, here they are put in order to check records
+// on chunk boundaries against the last timestamps of preceding chunks
+func checkMonotonic(data []byte) {
+	chunks := timeOrder.chunks
+	sort.Slice(chunks, func(i, j int) bool { return chunks[i].start < chunks[j].start })
+
+	last := make(map[string]time.Time)
+	for _, c := range chunks {
+		first := c.violation
+		for key, st := range c.stations {
+			prev, ok := last[key]
+			if ok && st.first.Before(prev) && (first == nil || st.firstOffset < first.offset) {
+				next := nextRecord(data, st.firstOffset+1)
+				first = &timeViolation{offset: st.firstOffset, record: data[st.firstOffset : next-1], prev: prev}
+			}
+		}
+		if first != nil {
+			previous := "previous record"
+			if opts.monotonic == "station" {
+				previous = "previous record of the station"
+			}
+			line := bytes.Count(data[:first.offset], []byte{'\n'}) + 1
+			panic(fmt.Errorf("line %d: timestamp of %q is before %s (%s)",
+				line, first.record, previous, first.prev.Format(time.RFC3339)))
+		}
+		for key, st := range c.stations {
+			last[key] = st.last
+		}
+	}
+}
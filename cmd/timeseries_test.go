@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestTimeBucket(t *testing.T) {
+	setFlags(t, "-time-bucket", "1h")
+	data := "" +
+		"2024-01-01T10:00:00Z;Paris;10.0\n" +
+		"2024-01-01T10:59:59Z;Paris;20.0\n" +
+		"2024-01-01T11:00:00Z;Paris;30.0\n" +
+		"1704106800;Oslo;-1.0\n" + // 2024-01-01T11:00:00Z as unix seconds
+		"2024-01-01T12:30:00+01:00;Oslo;-3.0\n" // 11:30 UTC
+	got := formatResults(aggregate(data, 1), "brc")
+	want := "{2024-01-01T10:00:00Z|Paris=10.0/15.0/20.0, " +
+		"2024-01-01T11:00:00Z|Oslo=-3.0/-2.0/-1.0, " +
+		"2024-01-01T11:00:00Z|Paris=30.0/30.0/30.0}"
+	if got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestMonotonicTimestamps(t *testing.T) {
+	const (
+		ordered = "2024-01-01T10:00:00Z;A;1.0\n2024-01-01T10:00:01Z;B;2.0\n2024-01-01T10:00:01Z;A;3.0\n2024-01-01T10:00:05Z;B;4.0\n"
+		// B goes back in time, but after A only: in order per station
+		interleaved = "2024-01-01T10:00:00Z;A;1.0\n2024-01-01T10:00:09Z;A;2.0\n2024-01-01T10:00:05Z;B;3.0\n2024-01-01T10:00:06Z;B;4.0\n"
+		backwards   = "2024-01-01T10:00:00Z;A;1.0\n2024-01-01T10:00:05Z;A;2.0\n2024-01-01T10:00:03Z;A;3.0\n2024-01-01T10:00:01Z;A;4.0\n"
+	)
+	for _, tt := range []struct {
+		mode, data string
+		want       string
+	}{
+		{"global", ordered, ""},
+		{"station", ordered, ""},
+		{"global", interleaved, `line 3: timestamp of "2024-01-01T10:00:05Z;B;3.0" is before previous record (2024-01-01T10:00:09Z)`},
+		{"station", interleaved, ""},
+		{"station", backwards, `line 3: timestamp of "2024-01-01T10:00:03Z;A;3.0" is before previous record of the station (2024-01-01T10:00:05Z)`},
+	} {
+		// chunk per record or two, so violations are found both inside chunks and on their boundaries
+		for _, chunkBytes := range []string{"1", "60", "1000"} {
+			setFlags(t, "-validate-monotonic-timestamps", tt.mode, "-chunk-bytes", chunkBytes)
+			timeOrder.chunks = nil
+			got := monotonicViolation([]byte(tt.data))
+			if got != tt.want {
+				t.Errorf("%s, chunks of %s bytes: got %q, want %q", tt.mode, chunkBytes, got, tt.want)
+			}
+		}
+	}
+	timeOrder.chunks = nil
+}
+
+// monotonicViolation scans data in chunks and returns error of checkMonotonic, empty if timestamps are in order
+func monotonicViolation(data []byte) (msg string) {
+	defer func() {
+		if r := recover(); r != nil {
+			msg = fmt.Sprint(r)
+		}
+	}()
+	mapScan(data, scan, 2)
+	checkMonotonic(data)
+	return ""
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parseTransform parses linear transform of value v into scale and offset (v*scale+offset).
+// It's not an expression engine, accepted forms are
+//
+//	v, v*2, 2*v, v/10, v+5, v-5, v*0.1+5, 1.8*v+32
+func parseTransform(expr string) (scale, offset float64, err error) {
+	s := strings.ReplaceAll(expr, " ", "")
+	scale = 1
+
+	i := strings.IndexByte(s, 'v')
+	if i < 0 {
+		return 0, 0, fmt.Errorf("%q has no v", expr)
+	}
+	prefix, rest := s[:i], s[i+1:]
+
+	// k*v
+	if prefix != "" {
+		num, ok := strings.CutSuffix(prefix, "*")
+		if !ok {
+			return 0, 0, fmt.Errorf("%q: expected * before v", expr)
+		}
+		if scale, err = strconv.ParseFloat(num, 64); err != nil {
+			return 0, 0, fmt.Errorf("%q: bad scale: %w", expr, err)
+		}
+	}
+
+	// v*k or v/k
+	if rest != "" && (rest[0] == '*' || rest[0] == '/') {
+		if prefix != "" {
+			return 0, 0, fmt.Errorf("%q: scale is set twice", expr)
+		}
+		op := rest[0]
+		end := offsetStart(rest)
+		k, err := strconv.ParseFloat(rest[1:end], 64)
+		if err != nil {
+			return 0, 0, fmt.Errorf("%q: bad scale: %w", expr, err)
+		}
+		if op == '/' {
+			if k == 0 {
+				return 0, 0, fmt.Errorf("%q: division by zero", expr)
+			}
+			k = 1 / k
+		}
+		scale = k
+		rest = rest[end:]
+	}
+
+	// +b or -b
+	if rest != "" {
+		if rest[0] != '+' && rest[0] != '-' {
+			return 0, 0, fmt.Errorf("%q: expected + or - offset", expr)
+		}
+		if offset, err = strconv.ParseFloat(rest, 64); err != nil {
+			return 0, 0, fmt.Errorf("%q: bad offset: %w", expr, err)
+		}
+	}
+
+	return scale, offset, nil
+}
+
+// offsetStart returns position of + or - which starts offset in `*k+b` like string,
+// signs right after operator or exponent belong to the number
+func offsetStart(s string) int {
+	for i := 2; i < len(s); i++ {
+		if (s[i] == '+' || s[i] == '-') && s[i-1] != 'e' && s[i-1] != 'E' {
+			return i
+		}
+	}
+	return len(s)
+}
@@ -0,0 +1,49 @@
+package main
+
+import "testing"
+
+func TestParseTransform(t *testing.T) {
+	for _, tt := range []struct {
+		expr          string
+		scale, offset float64
+		err           bool
+	}{
+		{expr: "v", scale: 1},
+		{expr: "v*2", scale: 2},
+		{expr: "2*v", scale: 2},
+		{expr: "v/10", scale: 0.1},
+		{expr: "v+5", scale: 1, offset: 5},
+		{expr: "v-5", scale: 1, offset: -5},
+		{expr: "v*0.1+5", scale: 0.1, offset: 5},
+		{expr: "1.8 * v + 32", scale: 1.8, offset: 32},
+		{expr: "v*1e-1-2", scale: 0.1, offset: -2},
+		{expr: "v*-2", scale: -2},
+		{expr: "x*2", err: true},
+		{expr: "2v", err: true},
+		{expr: "2*v*3", err: true},
+		{expr: "v/0", err: true},
+		{expr: "v*", err: true},
+		{expr: "v^2", err: true},
+		{expr: "v+five", err: true},
+	} {
+		scale, offset, err := parseTransform(tt.expr)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%q: no error", tt.expr)
+			}
+			continue
+		}
+		if err != nil || scale != tt.scale || offset != tt.offset {
+			t.Errorf("%q: got %v, %v, %v, want %v, %v", tt.expr, scale, offset, err, tt.scale, tt.offset)
+		}
+	}
+}
+
+func TestValueTransform(t *testing.T) {
+	setFlags(t, "-value-transform", "v*0.1+5")
+	// 10.0 -> 6.0, 20.0 -> 7.0, 60.0 -> 11.0, -50.0 -> 0.0
+	got := formatResults(aggregate("A;10.0\nA;20.0\nA;60.0\nB;-50.0\n", 2), "brc")
+	if want := "{A=6.0/8.0/11.0, B=0.0/0.0/0.0}"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
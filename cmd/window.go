@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"time"
+)
+
+// windowEntry is a single reading kept in sliding window
+type windowEntry struct {
+	ts    time.Time
+	value float64
+}
+
+// windowStation keeps station readings in arrival order
+type windowStation struct {
+	entries []windowEntry
+}
+
+// slidingWindow keeps readings of the last size of stream time,
+// stream time is the newest timestamp seen so far.
+// Memory is bounded by number of readings inside the window
+type slidingWindow struct {
+	size     time.Duration
+	newest   time.Time
+	stations map[string]*windowStation
+}
+
+func newSlidingWindow(size time.Duration) *slidingWindow {
+	return &slidingWindow{
+		size:     size,
+		stations: make(map[string]*windowStation),
+	}
+}
+
+func (w *slidingWindow) cutoff() time.Time {
+	return w.newest.Add(-w.size)
+}
+
+func (w *slidingWindow) add(ts time.Time, key []byte, value float64) {
+	if ts.After(w.newest) {
+		w.newest = ts
+	}
+
+	st := w.stations[string(key)]
+	if st == nil {
+		st = &windowStation{}
+		w.stations[string(key)] = st
+	}
+	st.entries = append(st.entries, windowEntry{ts: ts, value: value})
+	st.expire(w.cutoff())
+}
+
+// expire drops leading readings which are older than cutoff
+func (st *windowStation) expire(cutoff time.Time) {
+	i := 0
+	for i < len(st.entries) && !st.entries[i].ts.After(cutoff) {
+		i++
+	}
+	st.entries = st.entries[i:]
+}
+
+// snapshot recomputes aggregates of readings inside the window.
+// Readings are filtered by timestamp (not only expired from front),
+// so out of order records are counted correctly too
+func (w *slidingWindow) snapshot() map[string]Agg {
+	cutoff := w.cutoff()
+	out := make(map[string]Agg, len(w.stations))
+	for key, st := range w.stations {
+		st.expire(cutoff)
+		if len(st.entries) == 0 {
+			delete(w.stations, key)
+			continue
+		}
+
+		var agg Agg
+		for _, e := range st.entries {
+			if !e.ts.After(cutoff) {
+				continue
+			}
+			if agg.count == 0 {
+				agg = newAgg(e.value)
+			} else {
+				agg.Add(e.value)
+			}
+		}
+		if agg.count > 0 {
+			out[key] = agg
+		}
+	}
+	return out
+}
+
+// streamWindow reads timestamp;station;value records from r line by line
+// and returns aggregates over the last opts.window of stream time
+func streamWindow(r io.Reader) map[string]Agg {
+	w := newSlidingWindow(opts.window)
+	br := bufio.NewReader(r)
+	rows := 0
+	lineNum := 0 // rows counts processed lines only, with -sample-rate some are skipped
+	var scratch []byte
+	for {
+		line, err := br.ReadBytes('\n') // not ReadSlice, lines may be longer than buffer
+		if err != nil && err != io.EOF {
+			panic(err)
+		}
+		if len(line) > 0 && stopped() {
+			return w.snapshot()
+		}
+		if len(line) > 0 && opts.sample && !sampled(uint64(lineNum)) {
+			line = nil
+		}
+		lineNum++
+		if len(line) > 0 {
+			if line[len(line)-1] != '\n' {
+				line = append(line, '\n') // last line without newline
+			}
+			ts, i := parseTimestamp(line, 0)
+			key, value, _ := parseRecord(line, i, &scratch)
+			if !opts.filter || matchStation(key) {
+				if checkValue(key, value) {
+					w.add(ts, key, value)
+				} else if rejects != nil {
+					rejects.write(line)
+				}
+			}
+			rows++
+			flushes.maybe(w.snapshot)
+			snapshots.maybe(rows, w.snapshot)
+		}
+		if err == io.EOF {
+			return w.snapshot()
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWindowExpiry(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{
+			"expired values are dropped",
+			"2024-01-01T10:00:00Z;Paris;100.0\n" +
+				"2024-01-01T10:10:00Z;Oslo;5.0\n" +
+				"2024-01-01T10:30:00Z;Paris;1.0\n" +
+				"2024-01-01T11:20:00Z;Paris;3.0\n", // cutoff is 10:20, Oslo has nothing left
+			"{Paris=1.0/2.0/3.0}",
+		},
+		{
+			"late record older than window",
+			"2024-01-01T11:00:00Z;Paris;1.0\n" +
+				"2024-01-01T09:59:00Z;Paris;-50.0\n" +
+				"2024-01-01T10:30:00Z;Paris;3.0", // out of order, but inside window; no newline at the end
+			"{Paris=1.0/2.0/3.0}",
+		},
+		{
+			"boundary",
+			"2024-01-01T10:00:00Z;Paris;100.0\n" +
+				"2024-01-01T10:00:01Z;Paris;2.0\n" +
+				"2024-01-01T11:00:00Z;Paris;4.0\n", // exactly window old reading is expired
+			"{Paris=2.0/3.0/4.0}",
+		},
+		{
+			"line longer than read buffer",
+			"2024-01-01T10:00:00Z;" + strings.Repeat("P", 5000) + ";1.0\n" +
+				"2024-01-01T10:00:01Z;" + strings.Repeat("P", 5000) + ";3.0\n",
+			"{" + strings.Repeat("P", 5000) + "=1.0/2.0/3.0}",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			setFlags(t, "-input", "-", "-window", "1h")
+			got := formatResults(streamWindow(strings.NewReader(tt.input)), "brc")
+			if got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}